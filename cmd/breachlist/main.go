@@ -0,0 +1,105 @@
+// breachlist builds the breached password file used by the web application's -breached-passwords flag
+//
+// input lines are either SHA-1 hashes in hex, optionally followed by ":count" as in the
+// Have I Been Pwned downloads, or plain passwords when -plain is set
+// hashes must be sorted, as the Have I Been Pwned downloads are, and are streamed to the
+// output so the input does not have to fit in memory; plain passwords are hashed and sorted in memory
+//
+//	go run ./cmd/breachlist -in pwned-passwords-sha1.txt -out breached.bin
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"snippetbox.anukuljoshi/internals/validator"
+)
+
+func main() {
+	in := flag.String("in", "-", "Input file, - for stdin")
+	out := flag.String("out", "breached.bin", "Output file")
+	plain := flag.Bool("plain", false, "Input lines are plain passwords instead of SHA-1 hashes")
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	if *in!="-" {
+		f, err := os.Open(*in)
+		if err!=nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	f, err := os.Create(*out)
+	if err!=nil {
+		log.Fatal(err)
+	}
+	if *plain {
+		err = writePlain(f, r)
+	} else {
+		err = writeHashes(f, r)
+	}
+	if err!=nil {
+		f.Close()
+		os.Remove(*out)
+		log.Fatal(err)
+	}
+	err = f.Close()
+	if err!=nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %s\n", *out)
+}
+
+// hash plain passwords, which then have to be sorted in memory
+func writePlain(w io.Writer, r io.Reader) error {
+	var hashes [][sha1.Size]byte
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hashes = append(hashes, sha1.Sum(scanner.Bytes()))
+	}
+	if err := scanner.Err(); err!=nil {
+		return err
+	}
+	return validator.WriteBreachList(w, hashes)
+}
+
+// stream sorted hashes to the output, checking the order as they are read
+func writeHashes(w io.Writer, r io.Reader) error {
+	bw := validator.NewBreachListWriter(w)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if text=="" {
+			continue
+		}
+		b, err := hex.DecodeString(text)
+		if err!=nil || len(b)!=sha1.Size {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		var h [sha1.Size]byte
+		copy(h[:], b)
+		err = bw.Add(h)
+		if err!=nil {
+			if errors.Is(err, validator.ErrUnsortedHashes) {
+				return fmt.Errorf("line %d: hashes are not sorted, download the list ordered by hash or sort it first", line)
+			}
+			return err
+		}
+	}
+	if err := scanner.Err(); err!=nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -3,3 +3,9 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// role of the authenticated user, set by the authenticate middleware
+const userRoleContextKey = contextKey("userRole")
+
+// address of the client behind any trusted proxies, set by the realIP middleware
+const clientIPContextKey = contextKey("clientIP")
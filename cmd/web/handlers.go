@@ -5,41 +5,116 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"rsc.io/qr"
 	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/secrets"
+	"snippetbox.anukuljoshi/internals/sso"
+	"snippetbox.anukuljoshi/internals/totp"
 	"snippetbox.anukuljoshi/internals/validator"
 )
 
+// longest snippet content, a TEXT column holds 65535 bytes
+// and a character takes up to 4 bytes
+const maxContentLength = 16000
+
 // struct to hold form data and embedded validator
 // added struct tags for decoding form field names to struct fields
 type snippetCreateForm struct {
+	DraftID int `form:"draft_id"`
 	Title string `form:"title"`
 	Content string `form:"content"`
+	// code or markdown
+	Format string `form:"format"`
+	// language code is highlighted as, e.g. go, "" for plain text
+	Language string `form:"language"`
 	Expires int `form:"expires"`
+	// what to do with secrets found in the content, "confirm" or "redact"
+	Secrets string `form:"secrets"`
+	validator.Validator `form:"-"`
+}
+
+// struct to hold comment form data and embedded validator
+// line is 0 for a general comment, otherwise a line of the revision the form was shown for
+type commentForm struct {
+	Line int `form:"line"`
+	Revision string `form:"revision"`
+	Content string `form:"content"`
+	validator.Validator `form:"-"`
+}
+
+// struct to hold the star form data
+// starred is the state the user wants, so submitting the form twice has no further effect
+type starForm struct {
+	Starred bool `form:"starred"`
+}
+
+// struct to hold collection form data and embedded validator
+type collectionForm struct {
+	Name string `form:"name"`
+	Visibility string `form:"visibility"`
 	validator.Validator `form:"-"`
 }
 
+// struct to hold the data of the forms which add, remove or move a snippet in a collection
+// direction is either up or down
+type collectionSnippetForm struct {
+	CollectionID int `form:"collection_id"`
+	SnippetID int `form:"snippet_id"`
+	Direction string `form:"direction"`
+}
+
+// struct to hold the draft content sent by the create form for previewing
+type snippetPreviewForm struct {
+	Content string `form:"content"`
+	// code or markdown
+	Format string `form:"format"`
+	// language code is highlighted as, e.g. go, "" for plain text
+	Language string `form:"language"`
+}
+
 // struct to hold form data and embedded validator
 // added struct tags for decoding form field names to struct fields
 type userSignupForm struct {
 	Name string `form:"name"`
+	Username string `form:"username"`
 	Email string `form:"email"`
 	Password string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
+// usernames which can not be chosen as they clash with pages of the site
+// or could be mistaken for official accounts
+var reservedUsernames = []string{
+	"about", "account", "admin", "administrator", "api", "collection", "comment",
+	"create", "help", "login", "logout", "me", "moderator", "root", "settings",
+	"signup", "snippet", "snippetbox", "static", "staff", "support", "system", "user",
+}
+
 // struct to hold form data and embedded validator
 // added struct tags for decoding form field names to struct fields
 type userLoginForm struct {
 	Email string `form:"email"`
 	Password string `form:"password"`
+	RememberMe bool `form:"remember_me"`
 	validator.Validator `form:"-"`
 }
 
 // handler for home
+// shows the latest snippets or the most starred snippets with ?tab=popular
 func (app *application) home(w http.ResponseWriter,  r *http.Request){
-	snippets, err := app.snippets.Latest()
+	tab := r.URL.Query().Get("tab")
+	var snippets []*models.Snippet
+	var err error
+	if tab=="popular" {
+		snippets, err = app.snippets.Popular()
+	} else {
+		tab = "latest"
+		snippets, err = app.snippets.Latest()
+	}
 	if err!=nil {
 		app.serverError(w, err)
 		return
@@ -47,6 +122,7 @@ func (app *application) home(w http.ResponseWriter,  r *http.Request){
 	// call newTemplateData to create templateData with CurrentYear
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.Tab = tab
 	// use the render helper method
 	app.render(w, http.StatusOK, "home.tmpl.html", data)
 }
@@ -69,24 +145,106 @@ func (app *application) viewSnippet(w http.ResponseWriter,  r *http.Request){
 	snippet, err := app.snippets.Get(id)
 	if err!=nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+			app.snippetNotFound(w, r, id)
 			return
 		}
 		app.serverError(w, err)
+		return
 	}
-	// call newTemplateData to create templateData with CurrentYear
-	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	// call snippetViewData to create templateData with the snippet and its comments
+	data, err := app.snippetViewData(r, snippet)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data.Form = commentForm{}
 	// use render helper method
 	app.render(w, http.StatusOK, "view.tmpl.html", data)
 }
 
+// handler for viewing the content of a snippet as plain text
+// a range of lines can be requested with ?lines=12-20
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetNotFound(w, r, id)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	content := snippet.Content
+	if value := r.URL.Query().Get("lines"); value!="" {
+		start, end, ok := parseLineRange(value)
+		if !ok {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		lines := splitLines(snippet.Content, start, end)
+		if start > len(lines) {
+			app.clientError(w, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		texts := make([]string, 0, end-start+1)
+		for _, line := range lines[start-1:end] {
+			texts = append(texts, line.Text)
+		}
+		content = strings.Join(texts, "\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(content))
+}
+
 func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	// initialize snippetCreateForm struct to pass to template
-	data.Form = snippetCreateForm{
+	form := snippetCreateForm{
+		Format: models.FormatCode,
 		Expires: 365,
 	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	// restore a saved draft into the form if one was requested
+	// otherwise load the user's drafts so that the template can offer to restore them
+	if r.URL.Query().Has("draft") {
+		draftId, err := strconv.Atoi(r.URL.Query().Get("draft"))
+		if err!=nil || draftId < 1 {
+			app.notFound(w)
+			return
+		}
+		draft, err := app.drafts.Get(draftId, userId)
+		if err!=nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+				return
+			}
+			app.serverError(w, err)
+			return
+		}
+		form.DraftID = draft.ID
+		form.Title = draft.Title
+		form.Content = draft.Content
+		form.Format = draft.Format
+		form.Language = draft.Language
+		form.Expires = draft.Expires
+	} else {
+		drafts, err := app.drafts.ForUser(userId)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Drafts = drafts
+	}
+	data.Form = form
 	app.render(w, http.StatusOK, "create.tmpl.html", data)
 }
 
@@ -121,6 +279,18 @@ func (app *application) createSnippetPost(w http.ResponseWriter, r *http.Request
 		"content",
 		"This field cannot be blank",
 	)
+	// 2. content fits in the snippets table
+	form.CheckField(
+		validator.MaxLen(form.Content, maxContentLength),
+		"content",
+		fmt.Sprintf("This field cannot be more than %d characters long", maxContentLength),
+	)
+	// 3. content is shown as code or markdown, code in a language which can be highlighted
+	form.CheckField(
+		validContentFormat(form.Format, form.Language),
+		"format",
+		"This field must be code in a known language or markdown",
+	)
 	// validation checks for expires
 	// expires should be either 1, 7 or 365
 	form.CheckField(
@@ -135,31 +305,314 @@ func (app *application) createSnippetPost(w http.ResponseWriter, r *http.Request
 		app.render(w, http.StatusBadRequest, "create.tmpl.html", data)
 		return
 	}
+	// look for credentials pasted by accident
+	// the author has to confirm or redact them before the snippet is saved
+	// unless an admin has chosen to refuse such snippets, as every snippet is public
+	findings := secrets.Scan(form.Content)
+	if len(findings) > 0 {
+		block, err := app.settings.GetBool(models.SettingBlockSecrets)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		if block || (form.Secrets!="confirm" && form.Secrets!="redact") {
+			data := app.newTemplateData(r)
+			data.Form = form
+			data.SecretFindings = findings
+			data.BlockSecrets = block
+			app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+			return
+		}
+		if form.Secrets=="redact" {
+			form.Content = secrets.Redact(form.Content, findings)
+		}
+	}
 	// call insert for snippet model with data
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	id, err := app.snippets.Insert(form.Title, form.Content, form.Format, form.Language, form.Expires, userId)
 	if err!=nil {
 		app.serverError(w, err)
 		return
 	}
+	// the draft has been published so it is no longer needed
+	// the snippet already exists so only log the error if deleting it fails
+	if form.DraftID!=0 {
+		err = app.drafts.Delete(form.DraftID, userId)
+		if err!=nil {
+			app.errorLog.Print(err)
+		}
+	}
 	// use Put method of sessionManager to add a flash message to session
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created")
 	// redirect to snippet view for the created snippet id
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+// handler for previewing a snippet while it is being written
+// returns only the rendered content fragment which is shown beside the editor
+// the content is rendered the same way as on the view page
+func (app *application) previewSnippetPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetPreviewForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if !validContentFormat(form.Format, form.Language) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	data := &templateData{Form: form}
+	data.Lines, data.Markdown, err = renderContent(form.Content, form.Format, form.Language, 0, 0)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.renderPartial(w, http.StatusOK, "preview", data)
+}
+
+// handler for autosaving the create snippet form as a draft
+// responds with the id of the draft so that later saves update the same draft
+func (app *application) saveDraftPost(w http.ResponseWriter, r *http.Request) {
+	var form snippetCreateForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// drafts are saved before the form is finished, so only check what the table can hold
+	if !validator.MaxLen(form.Title, 100) || !validator.MaxLen(form.Content, maxContentLength) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if !validator.PermittedValue(form.Expires, 1, 7, 365) || !validContentFormat(form.Format, form.Language) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// drafts are only visible to their owner, so they are not scanned for secrets
+	// the content is scanned when the draft is published through createSnippetPost
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	id, err := app.drafts.Save(form.DraftID, userId, form.Title, form.Content, form.Format, form.Language, form.Expires)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{"id": id})
+}
+
+// handler for deleting a draft from the account page
+func (app *application) deleteDraftPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	err = app.drafts.Delete(id, userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Draft deleted")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// handler for posting a comment on a snippet
+func (app *application) commentSnippetPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	var form commentForm
+	err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Content),
+		"content",
+		"This field cannot be blank",
+	)
+	form.CheckField(
+		validator.MaxLen(form.Content, 5000),
+		"content",
+		"This field cannot be more than 5000 characters long",
+	)
+	// line must be 0 for a general comment or one of the lines of the snippet
+	form.CheckField(
+		form.Line >= 0 && form.Line <= strings.Count(snippet.Content, "\n")+1,
+		"line",
+		"This field must be a line of the snippet",
+	)
+	// the line numbers the commenter saw may not match if the content has changed
+	if form.Line > 0 {
+		form.CheckField(
+			form.Revision==snippet.Revision(),
+			"line",
+			"The snippet has changed since you loaded it, check the line and try again",
+		)
+	}
+	if !form.Valid() {
+		data, err := app.snippetViewData(r, snippet)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "view.tmpl.html", data)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	commentId, err := app.comments.Insert(snippet.ID, userId, form.Line, snippet.Revision(), form.Content)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment posted")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, commentId), http.StatusSeeOther)
+}
+
+// handler for starring or unstarring a snippet
+func (app *application) starSnippetPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	var form starForm
+	err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	err = app.stars.Set(userId, snippet.ID, form.Starred)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) editComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentForModification(w, r)
+	if !ok {
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Comment = comment
+	data.Form = commentForm{
+		Line: comment.Line,
+		Content: comment.Content,
+	}
+	app.render(w, http.StatusOK, "comment_edit.tmpl.html", data)
+}
+
+func (app *application) editCommentPost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentForModification(w, r)
+	if !ok {
+		return
+	}
+	var form commentForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Content),
+		"content",
+		"This field cannot be blank",
+	)
+	form.CheckField(
+		validator.MaxLen(form.Content, 5000),
+		"content",
+		"This field cannot be more than 5000 characters long",
+	)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Comment = comment
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "comment_edit.tmpl.html", data)
+		return
+	}
+	err = app.comments.Update(comment.ID, form.Content)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment updated")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetID, comment.ID), http.StatusSeeOther)
+}
+
+func (app *application) deleteCommentPost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentForModification(w, r)
+	if !ok {
+		return
+	}
+	err := app.comments.Delete(comment.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Comment deleted")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", comment.SnippetID), http.StatusSeeOther)
+}
+
 // user handlers
 func (app *application) userSignUp(w http.ResponseWriter, r *http.Request) {
+	app.renderSignUp(w, r, http.StatusOK, userSignupForm{})
+}
+
+// render the signup form with a new bot check challenge
+func (app *application) renderSignUp(w http.ResponseWriter, r *http.Request, status int, form userSignupForm) {
+	challenge, err := app.botCheck.Challenge()
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl.html", data)
+	data.Form = form
+	data.BotCheck = challenge
+	app.render(w, status, "signup.tmpl.html", data)
 }
 
 func (app *application) userSignUpPost(w http.ResponseWriter, r *http.Request) {
-	// initialize empty snippetCreateForm
+	// initialize empty userSignupForm
 	var form userSignupForm
-	// call decodePostForm helper method to decode data into snippetCreateForm struct
-	err := app.decodePostForm(r, &form)
+	// call decodeAnonymousForm helper method to decode data into userSignupForm struct
+	err := app.decodeAnonymousForm(r, &form)
 	if err!=nil {
+		// people can trip the checks too, e.g. by leaving the form open too long
+		// so let them try again with a new challenge
+		if errors.Is(err, errBotSubmission) {
+			form.AddNonFieldError("We could not check that you are not a bot. Please wait a few seconds and try again.")
+			app.renderSignUp(w, r, http.StatusUnprocessableEntity, form)
+			return
+		}
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
@@ -171,6 +624,9 @@ func (app *application) userSignUpPost(w http.ResponseWriter, r *http.Request) {
 		"name",
 		"This field cannot be blank",
 	)
+	// usernames are case insensitive so they are stored in lowercase
+	form.Username = strings.ToLower(strings.TrimSpace(form.Username))
+	validateUsername(&form.Validator, form.Username)
 	// email is not empty
 	form.CheckField(
 		validator.NotBlank(form.Email),
@@ -195,27 +651,40 @@ func (app *application) userSignUpPost(w http.ResponseWriter, r *http.Request) {
 		"password",
 		"This field must be at least 8 characters long",
 	)
+	// password is hard to guess
+	if form.Valid() {
+		err = app.checkPasswordStrength(&form.Validator, "password", form.Password, form.Name, form.Username, form.Email)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+	}
 	// re render form with data if invalid
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusBadRequest, "signup.tmpl.html", data)
+		app.renderSignUp(w, r, http.StatusBadRequest, form)
 		return
 	}
-	_, err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(form.Name, form.Username, form.Email, form.Password)
 	if err!=nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusBadRequest, "signup.tmpl.html", data)
+		if checkDuplicateEmail(&form.Validator, err) {
+			app.renderSignUp(w, r, http.StatusBadRequest, form)
+			return
+		}
+		if errors.Is(err, models.ErrDuplicateUsername) {
+			form.AddFieldError("username", "Username is already taken")
+			app.renderSignUp(w, r, http.StatusBadRequest, form)
 			return
 		}
 		app.serverError(w, err)
 		return
 	}
+	err = app.sendVerificationEmail(id, form.Name, form.Email)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
 	// add confirmation flash message
-	app.sessionManager.Put(r.Context(), "flash", "Your sign up was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash", "Your sign up was successful. We've sent you an email to verify your address. Please log in.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -257,11 +726,29 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		app.render(w, http.StatusBadRequest, "login.tmpl.html", data)
 		return
 	}
+	// refuse to check the password while the account or IP is locked
+	locked, err := app.loginLocked(r, form.Email)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked {
+		form.AddNonFieldError("Too many failed login attempts. Please try again later.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login.tmpl.html", data)
+		return
+	}
 	// check if credentials are correct
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	id, err := app.authenticator.Authenticate(form.Email, form.Password)
 	if err!=nil {
 		// add non field error to form if invalid credentials
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.recordFailedLogin(r, form.Email)
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
 			form.AddNonFieldError("Email or Password is incorrect")
 			data :=  app.newTemplateData(r)
 			data.Form = form
@@ -271,13 +758,33 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		app.serverError(w, err)
 		return
 	}
-	// use RenewToken() method on the current session
-	err = app.sessionManager.RenewToken(r.Context())
+	// users with two-factor authentication have to enter a code before they are logged in
+	pending, err := app.startTwoFactor(r, id, form.RememberMe)
+	if err!=nil {
+		if errors.Is(err, errAccountDisabled) {
+			form.AddNonFieldError("Your account has been disabled")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusForbidden, "login.tmpl.html", data)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	if pending {
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	err = app.loginAttempts.Reset(accountAttemptKey(form.Email))
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.logIn(r, id, form.RememberMe)
 	if err!=nil {
 		app.serverError(w, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "authenticatedUserId", id)
 	redirectURL := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
 	if redirectURL=="" {
 		redirectURL = "/"
@@ -286,8 +793,14 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	// forget the device of this session
+	err := app.userSessions.Delete(app.sessionManager.Token(r.Context()))
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
 	// user RenewToken() to change current session id
-	err := app.sessionManager.RenewToken(r.Context())
+	err = app.sessionManager.RenewToken(r.Context())
 	if err!=nil {
 		app.serverError(w, err)
 		return
@@ -309,76 +822,1804 @@ func (app *application) userAccount(w http.ResponseWriter, r *http.Request) {
 		app.serverError(w, err)
 		return
 	}
+	drafts, err := app.drafts.ForUser(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	collections, err := app.collections.ForUser(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	sessions, err := app.userSessions.ForUser(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	current := app.sessionManager.Token(r.Context())
 	data := app.newTemplateData((r))
 	data.User = user
+	data.Drafts = drafts
+	data.Collections = collections
+	for _, s := range sessions {
+		data.Sessions = append(data.Sessions, sessionView{UserSession: s, Current: s.Token==current})
+	}
 	app.render(w, http.StatusOK, "account.tmpl.html", data)
 }
 
-type updatePasswordForm struct {
-	CurrentPassword string `form:"current_password"`
-	NewPassword string `form:"new_password"`
-	ConfirmPassword string `form:"confirm_password"`
-	validator.Validator `form:"-"`
+// handler for listing the snippets starred by the current user
+func (app *application) userStarred(w http.ResponseWriter, r *http.Request) {
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	snippets, err := app.snippets.StarredBy(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, http.StatusOK, "starred.tmpl.html", data)
 }
 
-func (app *application) updatePassword(w http.ResponseWriter, r *http.Request) {
+// handler for the page of a collection looked up by id
+// ids are sequential, so only public collections can be viewed by anyone but their owner
+func (app *application) viewCollection(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	collection, err := app.collections.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	app.renderCollection(w, r, collection, models.VisibilityPublic)
+}
+
+// handler for the share link of a collection
+// unlisted collections can only be found through the random token in the link
+func (app *application) viewSharedCollection(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	collection, err := app.collections.GetByShareToken(params.ByName("token"))
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	app.renderCollection(w, r, collection, models.VisibilityPublic, models.VisibilityUnlisted)
+}
+
+// render the page of a collection if its visibility is one of visible
+// the owner can always view their own collections
+func (app *application) renderCollection(w http.ResponseWriter, r *http.Request, collection *models.Collection, visible ...string) {
+	var userId int
+	if app.isAuthenticated(r) {
+		userId = app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	}
+	if collection.UserID!=userId && !validator.PermittedValue(collection.Visibility, visible...) {
+		app.notFound(w)
+		return
+	}
+	snippets, err := app.snippets.InCollection(collection.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	data.Form = updatePasswordForm{}
-	app.render(w, http.StatusOK, "update_password.tmpl.html", data)
+	data.Collection = collection
+	data.Snippets = snippets
+	data.IsOwner = collection.UserID==userId
+	app.render(w, http.StatusOK, "collection.tmpl.html", data)
 }
 
-func (app *application) updatePasswordPost(w http.ResponseWriter, r *http.Request) {
-	var form updatePasswordForm
+func (app *application) createCollection(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = collectionForm{
+		Visibility: models.VisibilityPrivate,
+	}
+	app.render(w, http.StatusOK, "collection_form.tmpl.html", data)
+}
+
+func (app *application) createCollectionPost(w http.ResponseWriter, r *http.Request) {
+	var form collectionForm
 	var err = app.decodePostForm(r, &form)
 	if err!=nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	form.CheckField(
-		validator.NotBlank(form.CurrentPassword),
-		"current_password",
-		"This field cannot be blank",
-	)
-	form.CheckField(
-		validator.NotBlank(form.NewPassword),
-		"new_password",
-		"This field cannot be blank",
-	)
-	form.CheckField(
-		validator.MinLen(form.NewPassword, 8),
-		"new_password",
-		"This field must be at least 8 characters long",
-	)
-	form.CheckField(
-		validator.NotBlank(form.ConfirmPassword),
-		"confirm_password",
-		"This field cannot be blank",
-	)
-	form.CheckField(
-		validator.PermittedValue(form.ConfirmPassword, form.NewPassword),
-		"confirm_password",
-		"This field must be same as New Password",
-	)
+	validateCollectionForm(&form)
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusBadRequest, "update_password.tmpl.html", data)
+		app.render(w, http.StatusBadRequest, "collection_form.tmpl.html", data)
+		return
+	}
+	shareToken, err := newShareToken()
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	id, err := app.collections.Insert(userId, form.Name, form.Visibility, shareToken)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Collection created")
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) editCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.Form = collectionForm{
+		Name: collection.Name,
+		Visibility: collection.Visibility,
+	}
+	app.render(w, http.StatusOK, "collection_form.tmpl.html", data)
+}
+
+func (app *application) editCollectionPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	var form collectionForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	validateCollectionForm(&form)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Collection = collection
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "collection_form.tmpl.html", data)
+		return
+	}
+	err = app.collections.Update(collection.ID, form.Name, form.Visibility)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Collection updated")
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", collection.ID), http.StatusSeeOther)
+}
+
+func (app *application) deleteCollectionPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	err := app.collections.Delete(collection.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Collection deleted")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// handler for moving a collection up or down in the list on the account page
+func (app *application) moveCollectionPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	var form collectionSnippetForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil || !validator.PermittedValue(form.Direction, "up", "down") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.collections.Move(collection.UserID, collection.ID, form.Direction=="up")
+	if err!=nil {
+		app.serverError(w, err)
 		return
 	}
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
 
+// handler for adding a snippet to one of the user's collections from the snippet page
+func (app *application) addToCollectionPost(w http.ResponseWriter, r *http.Request) {
+	var form collectionSnippetForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	collection, err := app.collections.Get(form.CollectionID)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
 	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
-	err = app.users.UpdatePassword(userId, form.CurrentPassword, form.NewPassword)
+	if collection.UserID!=userId {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+	snippet, err := app.snippets.Get(form.SnippetID)
 	if err!=nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddFieldError("current_password", "Current password is incorrect")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusBadRequest, "update_password.tmpl.html", data)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
 			return
 		}
 		app.serverError(w, err)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Password updated successfully")
-	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+	err = app.collections.AddSnippet(collection.ID, snippet.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet added to %s", collection.Name))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) removeFromCollectionPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	var form collectionSnippetForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.collections.RemoveSnippet(collection.ID, form.SnippetID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Snippet removed from collection")
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", collection.ID), http.StatusSeeOther)
+}
+
+// handler for moving a snippet up or down within a collection
+func (app *application) moveInCollectionPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r)
+	if !ok {
+		return
+	}
+	var form collectionSnippetForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil || !validator.PermittedValue(form.Direction, "up", "down") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.collections.MoveSnippet(collection.ID, form.SnippetID, form.Direction=="up")
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", collection.ID), http.StatusSeeOther)
+}
+
+// handler for the public profile page of a user
+// only shows public information, never the user's email address
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	profile, err := app.users.GetProfileByUsername(strings.ToLower(params.ByName("username")))
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	snippets, err := app.snippets.ByUser(profile.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	collections, err := app.collections.PublicForUser(profile.ID)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Profile = profile
+	data.Snippets = snippets
+	data.Collections = collections
+	app.render(w, http.StatusOK, "profile.tmpl.html", data)
+}
+
+// struct to hold the edit profile form data and embedded validator
+// current password is only required when the email address is changed
+type userProfileForm struct {
+	Name string `form:"name"`
+	Username string `form:"username"`
+	Timezone string `form:"timezone"`
+	Email string `form:"email"`
+	CurrentPassword string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) updateProfile(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = userProfileForm{
+		Name: user.Name,
+		Username: user.Username,
+		Timezone: user.Timezone,
+		Email: user.Email,
+	}
+	app.render(w, http.StatusOK, "update_profile.tmpl.html", data)
+}
+
+func (app *application) updateProfilePost(w http.ResponseWriter, r *http.Request) {
+	var form userProfileForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	form.Username = strings.ToLower(strings.TrimSpace(form.Username))
+	form.Email = strings.TrimSpace(form.Email)
+	emailChanged := form.Email!=user.Email
+
+	form.CheckField(
+		validator.NotBlank(form.Name),
+		"name",
+		"This field cannot be blank",
+	)
+	validateUsername(&form.Validator, form.Username)
+	// timezone must be a known IANA time zone name
+	_, err = time.LoadLocation(form.Timezone)
+	form.CheckField(
+		validator.NotBlank(form.Timezone) && err==nil,
+		"timezone",
+		"This field must be a valid time zone, e.g. Europe/London",
+	)
+	form.CheckField(
+		validator.NotBlank(form.Email),
+		"email",
+		"This field cannot be blank",
+	)
+	form.CheckField(
+		validator.Matches(form.Email, validator.EmailRX),
+		"email",
+		"This field must be a valid email address",
+	)
+	if emailChanged {
+		form.CheckField(
+			validator.NotBlank(form.CurrentPassword),
+			"current_password",
+			"Enter your current password to change your email address",
+		)
+	}
+	if form.Valid() && emailChanged {
+		// wrong passwords count as failed logins so that a stolen session
+		// can not be used to guess the password
+		locked, err := app.loginLocked(r, user.Email)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		if locked {
+			form.AddFieldError("current_password", "Too many failed login attempts. Please try again later.")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusTooManyRequests, "update_profile.tmpl.html", data)
+			return
+		}
+		err = app.users.CheckPassword(id, form.CurrentPassword)
+		if err!=nil {
+			if !errors.Is(err, models.ErrInvalidCredentials) {
+				app.serverError(w, err)
+				return
+			}
+			err = app.recordFailedLogin(r, user.Email)
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
+			form.AddFieldError("current_password", "Current password is incorrect")
+		}
+		// the address is checked again when the change is confirmed
+		err = app.users.EmailAvailable(form.Email)
+		if err!=nil && !checkDuplicateEmail(&form.Validator, err) {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "update_profile.tmpl.html", data)
+		return
+	}
+	err = app.users.UpdateProfile(id, form.Name, form.Username, form.Timezone)
+	if err!=nil {
+		if errors.Is(err, models.ErrDuplicateUsername) {
+			form.AddFieldError("username", "Username is already taken")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusBadRequest, "update_profile.tmpl.html", data)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	// the new email address only replaces the current one once the link sent to it is followed
+	if emailChanged {
+		token, err := app.tokens.Sign("change-email", map[string]string{
+			"user": strconv.Itoa(id),
+			"from": user.Email,
+			"to": form.Email,
+		}, 24*time.Hour)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sendEmail(form.Email, "change_email.tmpl.html", map[string]string{
+			"Name": form.Name,
+			"Link": fmt.Sprintf("%s/user/email/verify?token=%s", app.baseURL, token),
+		})
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Profile updated. Follow the link sent to %s to confirm your new email address.", form.Email))
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Profile updated successfully")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// show the profile form with the email address which could not be confirmed
+// and the field error signup shows for it
+func (app *application) renderEmailTaken(w http.ResponseWriter, r *http.Request, id int, email string, duplicateErr error) {
+	user, err := app.users.Get(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	form := userProfileForm{
+		Name: user.Name,
+		Username: user.Username,
+		Timezone: user.Timezone,
+		Email: email,
+	}
+	checkDuplicateEmail(&form.Validator, duplicateErr)
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, http.StatusConflict, "update_profile.tmpl.html", data)
+}
+
+// handler for the link which confirms a change of email address
+func (app *application) verifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	data, err := app.tokens.Verify("change-email", r.URL.Query().Get("token"))
+	if err!=nil {
+		app.sessionManager.Put(r.Context(), "flash", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(data["user"])
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.users.UpdateEmail(id, data["from"], data["to"])
+	if err!=nil {
+		switch {
+		// another account took the address after the link was sent
+		case errors.Is(err, models.ErrDuplicateEmail):
+			if app.isAuthenticated(r) && app.sessionManager.GetInt(r.Context(), "authenticatedUserId")==id {
+				app.renderEmailTaken(w, r, id, data["to"], err)
+				return
+			}
+			app.sessionManager.Put(r.Context(), "flash", duplicateEmailMessage)
+		case errors.Is(err, models.ErrNoRecord):
+			app.sessionManager.Put(r.Context(), "flash", "This link has already been used")
+		default:
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been updated")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// handler for the link sent after signup which verifies the user's email address
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	data, err := app.tokens.Verify("verify-email", r.URL.Query().Get("token"))
+	if err!=nil {
+		app.sessionManager.Put(r.Context(), "flash", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(data["user"])
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.users.VerifyEmail(id, data["email"])
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This link is no longer valid")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handler for sending a new verification link from the account page
+func (app *application) resendVerificationPost(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+	err = app.sendVerificationEmail(user.ID, user.Name, user.Email)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've sent a new verification link to %s", user.Email))
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+type updatePasswordForm struct {
+	CurrentPassword string `form:"current_password"`
+	NewPassword string `form:"new_password"`
+	ConfirmPassword string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) updatePassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = updatePasswordForm{}
+	app.render(w, http.StatusOK, "update_password.tmpl.html", data)
+}
+
+func (app *application) updatePasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form updatePasswordForm
+	var err = app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.CurrentPassword),
+		"current_password",
+		"This field cannot be blank",
+	)
+	validateNewPassword(&form.Validator, form.NewPassword, form.ConfirmPassword)
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if form.Valid() {
+		user, err := app.users.Get(userId)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		err = app.checkPasswordStrength(&form.Validator, "new_password", form.NewPassword, user.Name, user.Username, user.Email)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "update_password.tmpl.html", data)
+		return
+	}
+
+	err = app.users.UpdatePassword(userId, form.CurrentPassword, form.NewPassword)
+	if err!=nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("current_password", "Current password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusBadRequest, "update_password.tmpl.html", data)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	// the password change logged the user out everywhere, keep them logged in here
+	err = app.keepOnlyCurrentSession(r, userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Password updated successfully. You have been logged out on all other devices.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+type forgotPasswordForm struct {
+	Email string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = forgotPasswordForm{}
+	app.render(w, http.StatusOK, "forgot_password.tmpl.html", data)
+}
+
+func (app *application) forgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Email),
+		"email",
+		"This field cannot be empty",
+	)
+	form.CheckField(
+		validator.Matches(form.Email, validator.EmailRX),
+		"email",
+		"This field must be a valid email address",
+	)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "forgot_password.tmpl.html", data)
+		return
+	}
+	// only send a link if an account exists
+	// the response is the same either way so it can not be used to find out who has an account
+	user, err := app.userByEmail(form.Email)
+	if err!=nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	if user!=nil {
+		token, hash, err := newResetToken()
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		err = app.resets.Insert(user.ID, hash, resetTokenLifetime)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sendEmail(user.Email, "reset_password.tmpl.html", map[string]string{
+			"Name": user.Name,
+			"Link": fmt.Sprintf("%s/user/password/reset/confirm?token=%s", app.baseURL, token),
+		})
+	}
+	app.sessionManager.Put(r.Context(), "flash", "If an account exists for that email address, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type resetPasswordForm struct {
+	Token string `form:"token"`
+	NewPassword string `form:"new_password"`
+	ConfirmPassword string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := app.resets.GetUserID(hashResetToken(token))
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired. Please request a new one.")
+			http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = resetPasswordForm{Token: token}
+	app.render(w, http.StatusOK, "reset_password.tmpl.html", data)
+}
+
+func (app *application) resetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	validateNewPassword(&form.Validator, form.NewPassword, form.ConfirmPassword)
+	if form.Valid() {
+		userId, err := app.resets.GetUserID(hashResetToken(form.Token))
+		if err!=nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired. Please request a new one.")
+				http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+				return
+			}
+			app.serverError(w, err)
+			return
+		}
+		user, err := app.users.Get(userId)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		err = app.checkPasswordStrength(&form.Validator, "new_password", form.NewPassword, user.Name, user.Username, user.Email)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "reset_password.tmpl.html", data)
+		return
+	}
+	// use up the token so the link can not be used again
+	// this also logs the user out of all existing sessions
+	userId, err := app.users.ResetPassword(hashResetToken(form.Token), form.NewPassword)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired. Please request a new one.")
+			http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	sessions, err := app.userSessions.ForUser(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	for _, s := range sessions {
+		err = app.deleteSession(s.Token)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type twoFactorForm struct {
+	Code string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// second step of logging in for users with two-factor authentication
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUser(r)==0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, http.StatusOK, "login_2fa.tmpl.html", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userId := app.pendingTwoFactorUser(r)
+	if userId==0 {
+		app.clearPendingTwoFactor(r)
+		app.sessionManager.Put(r.Context(), "flash", "Your login has timed out. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	var form twoFactorForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Code),
+		"code",
+		"This field cannot be empty",
+	)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "login_2fa.tmpl.html", data)
+		return
+	}
+	ok, usedRecoveryCode, err := app.checkTwoFactorCode(userId, form.Code)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	user, err := app.users.Get(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if !ok {
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorAttempts {
+			// running out of two-factor attempts counts as a failed login
+			err = app.recordFailedLogin(r, user.Email)
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+		form.AddFieldError("code", "This code is incorrect")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "login_2fa.tmpl.html", data)
+		return
+	}
+	rememberMe := app.sessionManager.GetBool(r.Context(), "twoFactorRememberMe")
+	app.clearPendingTwoFactor(r)
+	err = app.loginAttempts.Reset(accountAttemptKey(user.Email))
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.logIn(r, userId, rememberMe)
+	if err!=nil {
+		if errors.Is(err, errAccountDisabled) {
+			app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	if usedRecoveryCode {
+		left, err := app.recoveryCodes.Remaining(userId)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You logged in with a recovery code. You have %d recovery codes left.", left))
+	}
+	redirectURL := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if redirectURL=="" {
+		redirectURL = "/"
+	}
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// handler for enrolling in two-factor authentication
+// the new secret is kept in the session until the user confirms it with a code
+func (app *application) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSetupSecret")
+	if secret=="" {
+		secret, err = totp.GenerateSecret()
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorSetupSecret", secret)
+	}
+	data := app.newTemplateData(r)
+	data.TwoFactorSecret = secret
+	data.Form = twoFactorForm{}
+	app.render(w, http.StatusOK, "two_factor_setup.tmpl.html", data)
+}
+
+// serve the QR code of the secret being set up as a PNG image
+func (app *application) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSetupSecret")
+	if secret=="" {
+		app.notFound(w)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	code, err := qr.Encode(totp.URL("SnippetBox", user.Email, secret), qr.M)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+func (app *application) setupTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSetupSecret")
+	if secret=="" {
+		http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+		return
+	}
+	var form twoFactorForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Code),
+		"code",
+		"This field cannot be empty",
+	)
+	step, ok := totp.Validate(secret, form.Code, time.Now())
+	if form.Valid() && !ok {
+		form.AddFieldError("code", "This code is incorrect. Check the time on your device and try again")
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.TwoFactorSecret = secret
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "two_factor_setup.tmpl.html", data)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	err = app.users.EnableTOTP(userId, secret)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	// the code used for setup can not be used to log in
+	_, err = app.users.UseTOTPStep(userId, step)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "twoFactorSetupSecret")
+	app.showNewRecoveryCodes(w, r, userId, "Two-factor authentication is now enabled.")
+}
+
+// generate new recovery codes for user and show them once
+func (app *application) showNewRecoveryCodes(w http.ResponseWriter, r *http.Request, userId int, flash string) {
+	codes, hashes, err := newRecoveryCodes()
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.recoveryCodes.Replace(userId, hashes)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Flash = flash
+	data.RecoveryCodes = codes
+	app.render(w, http.StatusOK, "recovery_codes.tmpl.html", data)
+}
+
+type twoFactorManageForm struct {
+	Password string `form:"password"`
+	Action string `form:"action"`
+	validator.Validator `form:"-"`
+}
+
+// handler for managing two-factor authentication once it is enabled
+func (app *application) manageTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	user, err := app.users.Get(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+		return
+	}
+	app.renderManageTwoFactor(w, r, http.StatusOK, userId, twoFactorManageForm{})
+}
+
+func (app *application) renderManageTwoFactor(w http.ResponseWriter, r *http.Request, status int, userId int, form twoFactorManageForm) {
+	left, err := app.recoveryCodes.Remaining(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.RecoveryCodesLeft = left
+	data.Form = form
+	app.render(w, status, "two_factor.tmpl.html", data)
+}
+
+// regenerate recovery codes or disable two-factor authentication
+// both need the user's current password
+func (app *application) manageTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorManageForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if form.Action!="regenerate" && form.Action!="disable" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.NotBlank(form.Password),
+		"password",
+		"This field cannot be empty",
+	)
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if form.Valid() {
+		err = app.users.CheckPassword(userId, form.Password)
+		if err!=nil {
+			if !errors.Is(err, models.ErrInvalidCredentials) {
+				app.serverError(w, err)
+				return
+			}
+			form.AddFieldError("password", "Password is incorrect")
+		}
+	}
+	if !form.Valid() {
+		app.renderManageTwoFactor(w, r, http.StatusBadRequest, userId, form)
+		return
+	}
+	if form.Action=="regenerate" {
+		app.showNewRecoveryCodes(w, r, userId, "Your old recovery codes no longer work.")
+		return
+	}
+	err = app.users.DisableTOTP(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.recoveryCodes.DeleteForUser(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is now disabled.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// log out one of the current user's other devices
+func (app *application) revokeSessionPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	sessions, err := app.userSessions.ForUser(userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	for _, s := range sessions {
+		if s.ID!=id {
+			continue
+		}
+		if s.Token==app.sessionManager.Token(r.Context()) {
+			// logging out this device is the same as the normal logout
+			app.userLogoutPost(w, r)
+			return
+		}
+		err = app.deleteSession(s.Token)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", "The device has been logged out.")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+	app.notFound(w)
+}
+
+// log out all of the current user's devices except this one
+func (app *application) revokeOtherSessionsPost(w http.ResponseWriter, r *http.Request) {
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	err := app.revokeOtherSessions(r, userId)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "You have been logged out on all other devices.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+type passwordStrengthForm struct {
+	Password string `form:"password"`
+	Name string `form:"name"`
+	Username string `form:"username"`
+	Email string `form:"email"`
+}
+
+// returns the estimated strength of a password as JSON for the meter on password forms
+func (app *application) passwordStrengthPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordStrengthForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	userInputs := []string{form.Name, form.Username, form.Email}
+	// logged in users are changing their own password
+	if app.isAuthenticated(r) {
+		user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserId"))
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		userInputs = append(userInputs, user.Name, user.Username, user.Email)
+	}
+	strength := validator.PasswordStrength(form.Password, userInputs...)
+	breached, err := app.passwordBreached(form.Password)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	if breached {
+		strength.Score = 0
+		strength.Feedback = []string{"This password has appeared in a data breach"}
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"score": strength.Score,
+		"label": strength.Label(),
+		"feedback": strength.Feedback,
+		"acceptable": strength.Score >= minPasswordScore,
+	})
+}
+
+// send the user to the identity provider to log in
+func (app *application) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	if app.sso==nil {
+		app.notFound(w)
+		return
+	}
+	url, login, err := app.sso.Start()
+	if err!=nil {
+		app.errorLog.Print(err)
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on is not available right now. Please try again later.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	// keep the values needed to check the callback in the session
+	app.sessionManager.Put(r.Context(), "ssoState", login.State)
+	app.sessionManager.Put(r.Context(), "ssoNonce", login.Nonce)
+	app.sessionManager.Put(r.Context(), "ssoVerifier", login.Verifier)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// the identity provider sends the user back here after they logged in
+func (app *application) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	if app.sso==nil {
+		app.notFound(w)
+		return
+	}
+	login := &sso.Login{
+		State: app.sessionManager.PopString(r.Context(), "ssoState"),
+		Nonce: app.sessionManager.PopString(r.Context(), "ssoNonce"),
+		Verifier: app.sessionManager.PopString(r.Context(), "ssoVerifier"),
+	}
+	query := r.URL.Query()
+	if query.Get("error")!="" || login.State=="" {
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on was cancelled or failed. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	identity, err := app.sso.Finish(r.Context(), login, query.Get("state"), query.Get("code"))
+	if err!=nil {
+		app.errorLog.Print(err)
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on was cancelled or failed. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	userId, err := app.ssoUser(identity)
+	if err!=nil {
+		switch {
+		case errors.Is(err, errSSOEmailNotVerified):
+			app.sessionManager.Put(r.Context(), "flash", "Your single sign-on account has no verified email address.")
+		case errors.Is(err, errSSOAccountNotVerified):
+			app.sessionManager.Put(r.Context(), "flash", "An account with your email address already exists. Log in with your password and verify your email address to use single sign-on.")
+		default:
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	pending, err := app.startTwoFactor(r, userId, false)
+	if err!=nil {
+		if errors.Is(err, errAccountDisabled) {
+			app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	if pending {
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	err = app.logIn(r, userId, false)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	redirectURL := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if redirectURL=="" {
+		redirectURL = "/"
+	}
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// number of users or snippets on a page of the admin area
+const adminPageSize = 50
+
+// struct to hold the form data of the admin forms which change a user
+type adminUserForm struct {
+	Role string `form:"role"`
+	Action string `form:"action"`
+}
+
+// parse the page query parameter, pages start at 1
+func queryPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err!=nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// set the previous and next page links of a list in the admin area
+// the links keep the other query parameters, e.g. filters
+func setPages(data *templateData, r *http.Request, page int, more bool) {
+	query := r.URL.Query()
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page-1))
+		data.PrevPageURL = "?" + query.Encode()
+	}
+	if more {
+		query.Set("page", strconv.Itoa(page+1))
+		data.NextPageURL = "?" + query.Encode()
+	}
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.stats.Get()
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Stats = stats
+	data.Tab = "dashboard"
+	app.render(w, http.StatusOK, "admin.tmpl.html", data)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Search: strings.TrimSpace(query.Get("q")),
+		Role: query.Get("role"),
+		Status: query.Get("status"),
+		Page: queryPage(r),
+	}
+	users, more, err := app.users.List(filter, adminPageSize)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Roles = models.Roles
+	data.Filter = filter
+	data.Tab = "users"
+	setPages(data, r, filter.Page, more)
+	app.render(w, http.StatusOK, "admin_users.tmpl.html", data)
+}
+
+// change the role of a user
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	var form adminUserForm
+	err = app.decodePostForm(r, &form)
+	if err!=nil || models.RoleLevel(form.Role) < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// admins can not lock themselves out of the admin area
+	adminId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if id==adminId {
+		app.sessionManager.Put(r.Context(), "flash", "You can not change your own role")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	user, err := app.users.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	err = app.users.SetRole(id, form.Role)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("admin %d changed the role of user %d from %s to %s", adminId, id, user.Role, form.Role)
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is now %s", user.Name, form.Role))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// disable or enable the account of a user
+func (app *application) adminUserStatusPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	var form adminUserForm
+	err = app.decodePostForm(r, &form)
+	if err!=nil || (form.Action!="disable" && form.Action!="enable") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	adminId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if id==adminId {
+		app.sessionManager.Put(r.Context(), "flash", "You can not disable your own account")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	user, err := app.users.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	disabled := form.Action=="disable"
+	err = app.users.SetDisabled(id, disabled)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("admin %d %sd the account of user %d", adminId, form.Action, id)
+	if disabled {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account has been disabled", user.Name))
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account has been enabled", user.Name))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.SnippetFilter{
+		Search: strings.TrimSpace(query.Get("q")),
+		Author: strings.TrimSpace(query.Get("author")),
+		Status: query.Get("status"),
+		Page: queryPage(r),
+	}
+	snippets, more, err := app.snippets.List(filter, adminPageSize)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.AuthoredSnippets = snippets
+	data.Filter = filter
+	data.Tab = "snippets"
+	setPages(data, r, filter.Page, more)
+	app.render(w, http.StatusOK, "admin_snippets.tmpl.html", data)
+}
+
+// delete any snippet
+func (app *application) adminDeleteSnippetPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	err = app.snippets.Delete(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	adminId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	app.infoLog.Printf("admin %d deleted snippet %d", adminId, id)
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been deleted", id))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+// struct to hold the site settings form data
+type adminSettingsForm struct {
+	BlockSecrets bool `form:"block_secrets"`
+}
+
+func (app *application) adminSettings(w http.ResponseWriter, r *http.Request) {
+	blockSecrets, err := app.settings.GetBool(models.SettingBlockSecrets)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = adminSettingsForm{
+		BlockSecrets: blockSecrets,
+	}
+	data.Tab = "settings"
+	app.render(w, http.StatusOK, "admin_settings.tmpl.html", data)
+}
+
+func (app *application) adminSettingsPost(w http.ResponseWriter, r *http.Request) {
+	var form adminSettingsForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.settings.SetBool(models.SettingBlockSecrets, form.BlockSecrets)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	adminId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	app.infoLog.Printf("admin %d set %s to %t", adminId, models.SettingBlockSecrets, form.BlockSecrets)
+	app.sessionManager.Put(r.Context(), "flash", "Settings saved")
+	http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
+}
+
+// struct to hold the report form data and embedded validator
+type reportForm struct {
+	Category string `form:"category"`
+	Details string `form:"details"`
+	validator.Validator `form:"-"`
+}
+
+// load a snippet which the current user can report
+// returns nil after responding if the snippet can not be reported
+func (app *application) reportableSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return nil
+	}
+	snippet, err := app.snippets.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetNotFound(w, r, id)
+			return nil
+		}
+		app.serverError(w, err)
+		return nil
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if snippet.UserID==userId {
+		app.sessionManager.Put(r.Context(), "flash", "You can not report your own snippet")
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+		return nil
+	}
+	return snippet
+}
+
+func (app *application) reportSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.reportableSnippet(w, r)
+	if snippet==nil {
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.ReportCategories = models.ReportCategories
+	data.Form = reportForm{}
+	app.render(w, http.StatusOK, "report.tmpl.html", data)
+}
+
+func (app *application) reportSnippetPost(w http.ResponseWriter, r *http.Request) {
+	snippet := app.reportableSnippet(w, r)
+	if snippet==nil {
+		return
+	}
+	var form reportForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.PermittedValue(form.Category, models.ReportCategories...),
+		"category",
+		"Please choose why you are reporting the snippet",
+	)
+	form.CheckField(
+		validator.MaxLen(form.Details, 1000),
+		"details",
+		"This field cannot be more than 1000 characters long",
+	)
+	if form.Category==models.ReportOther {
+		form.CheckField(
+			validator.NotBlank(form.Details),
+			"details",
+			"Please tell us what is wrong with the snippet",
+		)
+	}
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.ReportCategories = models.ReportCategories
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "report.tmpl.html", data)
+		return
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	err = app.reports.Insert(snippet.ID, userId, form.Category, strings.TrimSpace(form.Details))
+	if err!=nil {
+		if errors.Is(err, models.ErrDuplicateReport) {
+			app.sessionManager.Put(r.Context(), "flash", "You have already reported this snippet")
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "Thank you, a moderator will review the snippet")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// number of decisions shown in the moderation log
+const moderationLogSize = 100
+
+// struct to hold the moderation decision form data and embedded validator
+type moderationForm struct {
+	Action string `form:"action"`
+	AuthorAction string `form:"author_action"`
+	Note string `form:"note"`
+	validator.Validator `form:"-"`
+}
+
+// the snippets with open reports
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	queue, err := app.reports.Queue()
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.ReportedSnippets = queue
+	data.Tab = "queue"
+	app.render(w, http.StatusOK, "moderation.tmpl.html", data)
+}
+
+// load a reported snippet and its open reports for review
+// returns nil after responding if the snippet does not exist
+func (app *application) moderationReviewData(w http.ResponseWriter, r *http.Request) *templateData {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return nil
+	}
+	snippet, err := app.snippets.GetForModeration(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return nil
+		}
+		app.serverError(w, err)
+		return nil
+	}
+	reports, err := app.reports.ForSnippet(id)
+	if err!=nil {
+		app.serverError(w, err)
+		return nil
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Lines, data.Markdown, err = renderContent(snippet.Content, snippet.Format, snippet.Language, 0, 0)
+	if err!=nil {
+		app.serverError(w, err)
+		return nil
+	}
+	// moderators review what was written, not only how it renders
+	data.ShowSource = true
+	data.Reports = reports
+	if snippet.UserID!=0 {
+		data.User, err = app.users.Get(snippet.UserID)
+		if err!=nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return nil
+		}
+	}
+	return data
+}
+
+func (app *application) moderationReview(w http.ResponseWriter, r *http.Request) {
+	data := app.moderationReviewData(w, r)
+	if data==nil {
+		return
+	}
+	data.Form = moderationForm{AuthorAction: "none"}
+	app.render(w, http.StatusOK, "moderation_review.tmpl.html", data)
+}
+
+// act on a reported snippet: hide it pending review, dismiss its reports,
+// or delete it and optionally warn or ban its author
+func (app *application) moderationReviewPost(w http.ResponseWriter, r *http.Request) {
+	data := app.moderationReviewData(w, r)
+	if data==nil {
+		return
+	}
+	snippet := data.Snippet
+	author := data.User
+	var form moderationForm
+	err := app.decodePostForm(r, &form)
+	if err!=nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(
+		validator.PermittedValue(form.Action, models.ModerationHide, models.ModerationDismiss, models.ModerationDelete),
+		"action",
+		"Please choose a decision",
+	)
+	form.CheckField(
+		validator.PermittedValue(form.AuthorAction, "none", models.ModerationWarn, models.ModerationBan),
+		"author_action",
+		"Please choose what happens to the author",
+	)
+	form.CheckField(
+		validator.MaxLen(form.Note, 1000),
+		"note",
+		"This field cannot be more than 1000 characters long",
+	)
+	if form.AuthorAction!="none" {
+		form.CheckField(
+			form.Action==models.ModerationDelete,
+			"author_action",
+			"The author can only be warned or banned when the snippet is deleted",
+		)
+		form.CheckField(
+			author!=nil,
+			"author_action",
+			"The snippet has no author",
+		)
+		// moderators can not ban other moderators or admins
+		if form.AuthorAction==models.ModerationBan && author!=nil {
+			form.CheckField(
+				models.RoleLevel(author.Role) < models.RoleLevel(app.userRole(r)),
+				"author_action",
+				"You can not ban this author",
+			)
+		}
+	}
+	if !form.Valid() {
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "moderation_review.tmpl.html", data)
+		return
+	}
+	moderatorId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	note := strings.TrimSpace(form.Note)
+	switch form.Action {
+	case models.ModerationHide:
+		err = app.snippets.SetHidden(snippet.ID, true)
+	case models.ModerationDismiss:
+		err = app.snippets.SetHidden(snippet.ID, false)
+		if err==nil {
+			err = app.reports.Resolve(snippet.ID, models.ReportDismissed)
+		}
+	case models.ModerationDelete:
+		err = app.reports.Resolve(snippet.ID, models.ReportActioned)
+		if err==nil {
+			err = app.snippets.Delete(snippet.ID)
+		}
+	}
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.logModeration(moderatorId, snippet, form.Action, note)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	switch form.AuthorAction {
+	case models.ModerationWarn:
+		app.sendEmail(author.Email, "moderation_warning.tmpl.html", map[string]string{
+			"Name": author.Name,
+			"Title": snippet.Title,
+			"Note": note,
+		})
+		err = app.logModeration(moderatorId, snippet, models.ModerationWarn, note)
+	case models.ModerationBan:
+		err = app.users.SetDisabled(author.ID, true)
+		if err==nil {
+			err = app.logModeration(moderatorId, snippet, models.ModerationBan, note)
+		}
+	}
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	switch form.Action {
+	case models.ModerationHide:
+		app.sessionManager.Put(r.Context(), "flash", "The snippet is hidden pending review")
+		http.Redirect(w, r, fmt.Sprintf("/moderation/snippet/%d", snippet.ID), http.StatusSeeOther)
+		return
+	case models.ModerationDismiss:
+		app.sessionManager.Put(r.Context(), "flash", "The reports have been dismissed")
+	case models.ModerationDelete:
+		app.sessionManager.Put(r.Context(), "flash", "The snippet has been deleted")
+	}
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// the latest moderation decisions
+func (app *application) moderationLog(w http.ResponseWriter, r *http.Request) {
+	entries, err := app.moderationLogs.Latest(moderationLogSize)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.ModerationLog = entries
+	data.Tab = "log"
+	app.render(w, http.StatusOK, "moderation_log.tmpl.html", data)
 }
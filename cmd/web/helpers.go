@@ -2,12 +2,29 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
+	"snippetbox.anukuljoshi/internals/botcheck"
+	"snippetbox.anukuljoshi/internals/mailer"
+	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/sso"
+	"snippetbox.anukuljoshi/internals/totp"
+	"snippetbox.anukuljoshi/internals/validator"
+	"snippetbox.anukuljoshi/ui"
 )
 
 // serverError writes error message and stack trace to error log
@@ -56,6 +73,37 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	buf.WriteTo(w)
 }
 
+// renderPartial writes a single partial template without the base layout
+// used for html fragments which are requested by javascript
+func (app *application) renderPartial(w http.ResponseWriter, status int, name string, data any) {
+	ts, ok := app.templateCache["partials"]
+	if !ok {
+		app.serverError(w, fmt.Errorf("the partial templates do not exist"))
+		return
+	}
+	buf := new(bytes.Buffer)
+	err := ts.ExecuteTemplate(buf, name, data)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// writeJSON encodes data as json and writes it to the response with status
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) {
+	js, err := json.Marshal(data)
+	if err!=nil {
+		app.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
 // helper method to decode PostForm data
 // dst is target destination we want the data to be decoded into
 func (app *application) decodePostForm(r *http.Request, dst any) error {
@@ -78,6 +126,28 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+var errBotSubmission = errors.New("form looks like it was submitted by a bot")
+
+// decode a form which anonymous users submit, like decodePostForm, and check the
+// honeypot, timing and proof of work fields added by the botcheck template
+// returns errBotSubmission if the checks fail
+func (app *application) decodeAnonymousForm(r *http.Request, dst any) error {
+	err := app.decodePostForm(r, dst)
+	if err!=nil {
+		return err
+	}
+	err = app.botCheck.Verify(
+		r.PostForm.Get(botcheck.HoneypotField),
+		r.PostForm.Get(botcheck.ChallengeField),
+		r.PostForm.Get(botcheck.SolutionField),
+	)
+	if err!=nil {
+		app.infoLog.Printf("rejected %s from %s: %v", r.URL.Path, clientIP(r), err)
+		return errBotSubmission
+	}
+	return nil
+}
+
 // checks if current request user is authenticated
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
@@ -86,3 +156,850 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated 
 }
+
+// respond to a request for a snippet which does not exist or has expired with 404 Not Found
+// snippets hidden by a moderator get a 451 Unavailable For Legal Reasons page instead
+func (app *application) snippetNotFound(w http.ResponseWriter, r *http.Request, id int) {
+	hidden, err := app.snippets.IsHidden(id)
+	if err!=nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	if !hidden {
+		app.notFound(w)
+		return
+	}
+	data := app.newTemplateData(r)
+	app.render(w, http.StatusUnavailableForLegalReasons, "unavailable.tmpl.html", data)
+}
+
+// log a moderation action on a snippet to the moderation log and the info log
+func (app *application) logModeration(moderatorId int, snippet *models.Snippet, action, note string) error {
+	app.infoLog.Printf("moderator %d: %s snippet %d by user %d", moderatorId, action, snippet.ID, snippet.UserID)
+	return app.moderationLogs.Insert(moderatorId, snippet.ID, snippet.Title, snippet.UserID, action, note)
+}
+
+// returns the role of the current user, or "" if the request is not authenticated
+func (app *application) userRole(r *http.Request) string {
+	role, _ := r.Context().Value(userRoleContextKey).(string)
+	return role
+}
+
+// checks if the current user has role or a more privileged one
+func (app *application) hasRole(r *http.Request, role string) bool {
+	current := app.userRole(r)
+	return current!="" && models.RoleLevel(current) >= models.RoleLevel(role)
+}
+
+// returns the key used to rate limit the current request
+// authenticated users are limited by their id, everyone else by ip address
+func (app *application) rateLimitKey(r *http.Request) string {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if id!=0 {
+		return fmt.Sprintf("user:%d", id)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// deletes expired drafts every interval, meant to be run in its own goroutine
+func (app *application) deleteExpiredDrafts(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := app.drafts.DeleteExpired()
+		if err!=nil {
+			app.errorLog.Print(err)
+		}
+	}
+}
+
+// a single line of snippet content as shown on the view page
+// along with the comments attached to it
+type snippetLine struct {
+	Number int
+	Text string
+	// text highlighted as code, empty for the source lines of markdown
+	HTML template.HTML
+	Highlighted bool
+	Comments []commentView
+}
+
+// a comment and whether the current user is allowed to edit or delete it
+type commentView struct {
+	*models.Comment
+	Editable bool
+	// made on a line of an earlier revision of the snippet
+	Outdated bool
+}
+
+// comments can be modified by their author and by the owner of the snippet
+// snippet is nil if it has expired
+func canModifyComment(userId int, comment *models.Comment, snippet *models.Snippet) bool {
+	if userId==0 {
+		return false
+	}
+	return comment.UserID==userId || (snippet!=nil && snippet.UserID==userId)
+}
+
+// builds the template data for the view page of a snippet
+// comments on a line are shown below that line, the others beneath the snippet
+func (app *application) snippetViewData(r *http.Request, snippet *models.Snippet) (*templateData, error) {
+	// highlight the lines requested in the query string, e.g. ?lines=12-20
+	var start, end int
+	if value := r.URL.Query().Get("lines"); value!="" {
+		start, end, _ = parseLineRange(value)
+	}
+	comments, err := app.comments.ForSnippet(snippet.ID)
+	if err!=nil {
+		return nil, err
+	}
+	var userId int
+	if app.isAuthenticated(r) {
+		userId = app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Lines, data.Markdown, err = renderContent(snippet.Content, snippet.Format, snippet.Language, start, end)
+	if err!=nil {
+		return nil, err
+	}
+	// linked lines of markdown snippets are in the source
+	data.ShowSource = start > 0
+	data.IsOwner = userId!=0 && snippet.UserID==userId
+	// show who wrote the snippet if the author has a public profile
+	if snippet.UserID!=0 {
+		data.Profile, err = app.users.GetProfile(snippet.UserID)
+		if err!=nil && !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+	}
+	if userId!=0 {
+		data.Starred, err = app.stars.Exists(userId, snippet.ID)
+		if err!=nil {
+			return nil, err
+		}
+		// the user's collections are offered in the add to collection form
+		data.Collections, err = app.collections.ForUser(userId)
+		if err!=nil {
+			return nil, err
+		}
+	}
+	// line comments are only shown inline on the revision they were made on
+	revision := snippet.Revision()
+	for _, comment := range comments {
+		view := commentView{
+			Comment: comment,
+			Editable: canModifyComment(userId, comment, snippet),
+			Outdated: comment.Line > 0 && comment.Revision!=revision,
+		}
+		if comment.Line > 0 && comment.Line <= len(data.Lines) && !view.Outdated {
+			line := &data.Lines[comment.Line-1]
+			line.Comments = append(line.Comments, view)
+			data.ShowSource = true
+			continue
+		}
+		data.Comments = append(data.Comments, view)
+	}
+	return data, nil
+}
+
+// loads the comment with the id in the url and checks that the current user may modify it
+// writes an error response and returns ok as false otherwise
+func (app *application) commentForModification(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+	comment, err := app.comments.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return nil, false
+		}
+		app.serverError(w, err)
+		return nil, false
+	}
+	// the snippet may have expired, in which case only the author can modify the comment
+	snippet, err := app.snippets.Get(comment.SnippetID)
+	if err!=nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return nil, false
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if !canModifyComment(userId, comment, snippet) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return comment, true
+}
+
+// split content into numbered lines
+// lines from start to end (inclusive) are marked as highlighted
+func splitLines(content string, start, end int) []snippetLine {
+	texts := strings.Split(content, "\n")
+	lines := make([]snippetLine, len(texts))
+	for i, text := range texts {
+		number := i + 1
+		lines[i] = snippetLine{
+			Number: number,
+			// textarea content is submitted with CRLF line endings
+			Text: strings.TrimSuffix(text, "\r"),
+			Highlighted: number >= start && number <= end,
+		}
+	}
+	return lines
+}
+
+// parse a line range such as "12", "12-20" or "L12-L20"
+// returns ok as false if value is not a valid range
+func parseLineRange(value string) (start, end int, ok bool) {
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		last = first
+	}
+	start, err := strconv.Atoi(strings.TrimPrefix(first, "L"))
+	if err!=nil {
+		return 0, 0, false
+	}
+	end, err = strconv.Atoi(strings.TrimPrefix(last, "L"))
+	if err!=nil {
+		return 0, 0, false
+	}
+	if start > end {
+		start, end = end, start
+	}
+	if start < 1 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// loads the collection with the id in the url and checks that it belongs to the current user
+// writes an error response and returns ok as false otherwise
+func (app *application) ownCollection(w http.ResponseWriter, r *http.Request) (*models.Collection, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err!=nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+	collection, err := app.collections.Get(id)
+	if err!=nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return nil, false
+		}
+		app.serverError(w, err)
+		return nil, false
+	}
+	userId := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+	if collection.UserID!=userId {
+		app.notFound(w)
+		return nil, false
+	}
+	return collection, true
+}
+
+// validation checks shared by the create and edit collection forms
+func validateCollectionForm(form *collectionForm) {
+	form.CheckField(
+		validator.NotBlank(form.Name),
+		"name",
+		"This field cannot be blank",
+	)
+	form.CheckField(
+		validator.MaxLen(form.Name, 100),
+		"name",
+		"This field cannot be more than 100 characters long",
+	)
+	form.CheckField(
+		validator.PermittedValue(form.Visibility, models.VisibilityPrivate, models.VisibilityUnlisted, models.VisibilityPublic),
+		"visibility",
+		"This field must be private, unlisted or public",
+	)
+}
+
+// shown when an email address belongs to another account
+const duplicateEmailMessage = "Email address is already in use"
+
+// add the email field error for err if it is models.ErrDuplicateEmail
+// signup and email changes share it so the same error is shown however the duplicate is found
+func checkDuplicateEmail(v *validator.Validator, err error) bool {
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		return false
+	}
+	v.AddFieldError("email", duplicateEmailMessage)
+	return true
+}
+
+// validation checks for a username, username must already be lowercase
+func validateUsername(v *validator.Validator, username string) {
+	v.CheckField(
+		validator.NotBlank(username),
+		"username",
+		"This field cannot be blank",
+	)
+	v.CheckField(
+		validator.MinLen(username, 3),
+		"username",
+		"This field must be at least 3 characters long",
+	)
+	v.CheckField(
+		validator.MaxLen(username, 30),
+		"username",
+		"This field cannot be more than 30 characters long",
+	)
+	v.CheckField(
+		validator.Matches(username, validator.UsernameRX),
+		"username",
+		"This field can only contain letters, digits, hyphens and underscores",
+	)
+	v.CheckField(
+		!validator.PermittedValue(username, reservedUsernames...),
+		"username",
+		"This username is reserved",
+	)
+}
+
+// render the email template file in ui/html/emails with data and send it to recipient
+// the email is sent in the background so that a slow mail server does not hold up the response
+func (app *application) sendEmail(recipient, file string, data any) {
+	msg, err := mailer.NewMessage(ui.Files, "html/emails/"+file, recipient, data)
+	if err!=nil {
+		app.errorLog.Print(err)
+		return
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err!=nil {
+				app.errorLog.Print(fmt.Errorf("%s", err))
+			}
+		}()
+		err := app.mailer.Send(msg)
+		if err!=nil {
+			app.errorLog.Print(err)
+		}
+	}()
+}
+
+// send a signed link to email which verifies that it belongs to the user
+func (app *application) sendVerificationEmail(userId int, name, email string) error {
+	token, err := app.tokens.Sign("verify-email", map[string]string{
+		"user": strconv.Itoa(userId),
+		"email": email,
+	}, 24*time.Hour)
+	if err!=nil {
+		return err
+	}
+	app.sendEmail(email, "verify_email.tmpl.html", map[string]string{
+		"Name": name,
+		"Link": fmt.Sprintf("%s/user/verify?token=%s", app.baseURL, token),
+	})
+	return nil
+}
+
+// check a new password and its confirmation
+// shared by the update password and reset password forms
+func validateNewPassword(v *validator.Validator, newPassword, confirmPassword string) {
+	v.CheckField(
+		validator.NotBlank(newPassword),
+		"new_password",
+		"This field cannot be blank",
+	)
+	v.CheckField(
+		validator.MinLen(newPassword, 8),
+		"new_password",
+		"This field must be at least 8 characters long",
+	)
+	v.CheckField(
+		validator.NotBlank(confirmPassword),
+		"confirm_password",
+		"This field cannot be blank",
+	)
+	v.CheckField(
+		validator.PermittedValue(confirmPassword, newPassword),
+		"confirm_password",
+		"This field must be same as New Password",
+	)
+}
+
+// password reset links are only valid for a short time
+const resetTokenLifetime = time.Hour
+
+// return the user with email
+func (app *application) userByEmail(email string) (*models.User, error) {
+	id, err := app.users.GetIDByEmail(email)
+	if err!=nil {
+		return nil, err
+	}
+	return app.users.Get(id)
+}
+
+// generate the random token of a collection's share link
+// 16 bytes encode to the 22 characters of the share_token column
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err!=nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generate a random single-use token and the hash which is stored in the database
+func newResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err!=nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var errAccountDisabled = errors.New("account is disabled")
+
+// refuse to log in users whose account was disabled by an admin
+func (app *application) checkAccountEnabled(userId int) error {
+	disabled, err := app.users.Disabled(userId)
+	if err!=nil {
+		return err
+	}
+	if disabled {
+		return errAccountDisabled
+	}
+	return nil
+}
+
+// log the current session in as user
+// the session stores the user's session epoch so it can be revoked later
+// remembered sessions get a persistent cookie, others end when the browser is closed
+func (app *application) logIn(r *http.Request, userId int, rememberMe bool) error {
+	err := app.checkAccountEnabled(userId)
+	if err!=nil {
+		return err
+	}
+	epoch, err := app.users.SessionEpoch(userId)
+	if err!=nil {
+		return err
+	}
+	// use RenewToken() method on the current session
+	err = app.sessionManager.RenewToken(r.Context())
+	if err!=nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "authenticatedUserId", userId)
+	app.sessionManager.Put(r.Context(), "sessionEpoch", epoch)
+	app.sessionManager.Put(r.Context(), "loginAt", time.Now().Unix())
+	app.sessionManager.Put(r.Context(), "rememberMe", rememberMe)
+	app.sessionManager.RememberMe(r.Context(), rememberMe)
+	app.extendRememberedLogin(r)
+	// remember the device so the user can see and revoke the session
+	return app.userSessions.Insert(app.sessionManager.Token(r.Context()), userId, r.UserAgent(), clientIP(r), rememberMe)
+}
+
+// check if a login without remember me has lasted longer than sessionLifetime
+// such sessions reach their deadline then as well, this also ends the ones committed
+// with a longer deadline before it was set per login
+// sessions from before remember me was added have no login time and expire on their own
+func (app *application) loginExpired(r *http.Request) bool {
+	if app.sessionManager.GetBool(r.Context(), "rememberMe") {
+		return false
+	}
+	loginAt := app.sessionManager.GetInt64(r.Context(), "loginAt")
+	return loginAt!=0 && time.Since(time.Unix(loginAt, 0)) > app.sessionLifetime
+}
+
+// sessions are shown as last seen at most this long ago on the account page
+const sessionTouchInterval = time.Minute
+
+// record when and from where the current session was last used
+// the time and address are also kept in the session so that the database
+// is only updated once a minute or when the address changes
+func (app *application) touchSession(r *http.Request) error {
+	ip := clientIP(r)
+	seen := app.sessionManager.GetTime(r.Context(), "lastSeen")
+	if time.Since(seen) < sessionTouchInterval && app.sessionManager.GetString(r.Context(), "lastSeenIP")==ip {
+		return nil
+	}
+	err := app.userSessions.Touch(app.sessionManager.Token(r.Context()), ip)
+	if err!=nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "lastSeen", time.Now())
+	app.sessionManager.Put(r.Context(), "lastSeenIP", ip)
+	app.extendRememberedLogin(r)
+	return nil
+}
+
+// move the deadline of a remembered login to rememberMeIdle from now
+// but no further than rememberMeLifetime after logging in
+// the session and its cookie expire at the deadline, so an unused login ends after rememberMeIdle
+func (app *application) extendRememberedLogin(r *http.Request) {
+	if !app.sessionManager.GetBool(r.Context(), "rememberMe") {
+		return
+	}
+	deadline := time.Now().Add(app.rememberMeIdle)
+	loginAt := app.sessionManager.GetInt64(r.Context(), "loginAt")
+	if end := time.Unix(loginAt, 0).Add(app.rememberMeLifetime); end.Before(deadline) {
+		deadline = end
+	}
+	app.sessionManager.SetDeadline(r.Context(), deadline.UTC())
+}
+
+// a session of the current user shown on the account page
+type sessionView struct {
+	*models.UserSession
+	Current bool
+}
+
+// revoke all sessions of a user except the current one
+// sessions which are not tracked are revoked through the user's session epoch
+func (app *application) revokeOtherSessions(r *http.Request, userId int) error {
+	err := app.users.RevokeSessions(userId)
+	if err!=nil {
+		return err
+	}
+	return app.keepOnlyCurrentSession(r, userId)
+}
+
+// delete all tracked sessions of a user except the current one
+// and move the current session to the user's latest session epoch
+// used after the epoch was bumped, e.g. when the password changed
+func (app *application) keepOnlyCurrentSession(r *http.Request, userId int) error {
+	epoch, err := app.users.SessionEpoch(userId)
+	if err!=nil {
+		return err
+	}
+	current := app.sessionManager.Token(r.Context())
+	sessions, err := app.userSessions.ForUser(userId)
+	if err!=nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.Token==current {
+			continue
+		}
+		err = app.deleteSession(s.Token)
+		if err!=nil {
+			return err
+		}
+	}
+	app.sessionManager.Put(r.Context(), "sessionEpoch", epoch)
+	return nil
+}
+
+// delete a session from the session store and forget its device
+func (app *application) deleteSession(token string) error {
+	err := app.sessionManager.Store.Delete(token)
+	if err!=nil {
+		return err
+	}
+	return app.userSessions.Delete(token)
+}
+
+// users have a few minutes to enter their two-factor code after entering their password
+const twoFactorTimeout = 5*time.Minute
+
+// number of wrong two-factor codes after which the user has to log in again
+const maxTwoFactorAttempts = 5
+
+// return the id of the user who entered their password and still has to enter a two-factor code
+// returns 0 if there is no such user or they took too long
+func (app *application) pendingTwoFactorUser(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserId")
+	started := app.sessionManager.GetInt64(r.Context(), "twoFactorStarted")
+	if id==0 || time.Since(time.Unix(started, 0)) > twoFactorTimeout {
+		return 0
+	}
+	return id
+}
+
+// start the two-factor step of logging in if the user has enabled it
+// returns false if the user can be logged in straight away
+func (app *application) startTwoFactor(r *http.Request, userId int, rememberMe bool) (bool, error) {
+	err := app.checkAccountEnabled(userId)
+	if err!=nil {
+		return false, err
+	}
+	secret, err := app.users.TOTPSecret(userId)
+	if err!=nil {
+		return false, err
+	}
+	if secret=="" {
+		return false, nil
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err!=nil {
+		return false, err
+	}
+	app.sessionManager.Put(r.Context(), "twoFactorUserId", userId)
+	app.sessionManager.Put(r.Context(), "twoFactorStarted", time.Now().Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorAttempts", 0)
+	app.sessionManager.Put(r.Context(), "twoFactorRememberMe", rememberMe)
+	return true, nil
+}
+
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserId")
+	app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+	app.sessionManager.Remove(r.Context(), "twoFactorRememberMe")
+}
+
+// check a two-factor code or a recovery code of a user
+// recovery codes are used up and the returned bool tells if one was used
+func (app *application) checkTwoFactorCode(userId int, code string) (bool, bool, error) {
+	secret, err := app.users.TOTPSecret(userId)
+	if err!=nil {
+		return false, false, err
+	}
+	if secret=="" {
+		return false, false, nil
+	}
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		// make sure the same code can not be used twice
+		ok, err = app.users.UseTOTPStep(userId, step)
+		return ok, false, err
+	}
+	ok, err := app.recoveryCodes.Use(userId, hashRecoveryCode(code))
+	return ok, ok, err
+}
+
+const recoveryCodeCount = 10
+
+// generate a new set of recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err!=nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hash a recovery code ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// failed logins for an account lock it after a few attempts
+// the lock doubles with every further failure
+var accountLoginPolicy = models.LoginPolicy{
+	Threshold: 5,
+	Backoff: time.Minute,
+	MaxBackoff: time.Hour,
+	Window: 24*time.Hour,
+}
+
+// an IP gets more attempts since several users may share it
+var ipLoginPolicy = models.LoginPolicy{
+	Threshold: 20,
+	Backoff: time.Minute,
+	MaxBackoff: time.Hour,
+	Window: time.Hour,
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipAttemptKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// check if logins for email or from the client's IP are locked because of failed attempts
+func (app *application) loginLocked(r *http.Request, email string) (bool, error) {
+	for _, key := range []string{accountAttemptKey(email), ipAttemptKey(r)} {
+		wait, err := app.loginAttempts.Locked(key)
+		if err!=nil {
+			return false, err
+		}
+		if wait > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// count a failed login for email and the client's IP
+// the owner of the account is told by email when it gets locked
+func (app *application) recordFailedLogin(r *http.Request, email string) error {
+	failures, err := app.loginAttempts.Fail(ipAttemptKey(r), ipLoginPolicy)
+	if err!=nil {
+		return err
+	}
+	if failures==ipLoginPolicy.Threshold {
+		app.errorLog.Printf("WARNING: logins from %s locked after %d failed attempts", clientIP(r), failures)
+	}
+	failures, err = app.loginAttempts.Fail(accountAttemptKey(email), accountLoginPolicy)
+	if err!=nil {
+		return err
+	}
+	if failures==accountLoginPolicy.Threshold {
+		app.errorLog.Printf("WARNING: logins for %s locked after %d failed attempts, last from %s", email, failures, clientIP(r))
+		user, err := app.userByEmail(email)
+		if err!=nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return nil
+			}
+			return err
+		}
+		app.sendEmail(user.Email, "account_locked.tmpl.html", map[string]string{
+			"Name": user.Name,
+			"IP": clientIP(r),
+			"Link": app.baseURL + "/user/password/reset",
+		})
+	}
+	return nil
+}
+
+// passwords need at least a fair strength score
+const minPasswordScore = 2
+
+// check that password is hard to guess and has not appeared in a data breach
+// userInputs are the user's own details such as their name and email
+func (app *application) checkPasswordStrength(v *validator.Validator, field, password string, userInputs ...string) error {
+	strength := validator.PasswordStrength(password, userInputs...)
+	if strength.Score < minPasswordScore {
+		message := "This password is too easy to guess"
+		if len(strength.Feedback) > 0 {
+			message += ". " + strength.Feedback[0]
+		}
+		v.AddFieldError(field, message)
+		return nil
+	}
+	breached, err := app.passwordBreached(password)
+	if err!=nil {
+		return err
+	}
+	v.CheckField(!breached, field, "This password has appeared in a data breach. Please choose another one")
+	return nil
+}
+
+// check if password is in the breached password list, if there is one
+func (app *application) passwordBreached(password string) (bool, error) {
+	if app.breachList==nil {
+		return false, nil
+	}
+	return app.breachList.Contains(password)
+}
+
+var (
+	errSSOEmailNotVerified = errors.New("identity provider did not verify the email address")
+	errSSOAccountNotVerified = errors.New("existing account with the email address is not verified")
+)
+
+// return the user an identity from the identity provider belongs to
+// identities are linked to an existing user with the same verified email address
+// or a new user is created for them
+func (app *application) ssoUser(identity *sso.Identity) (int, error) {
+	userId, err := app.identities.GetUserID(identity.Issuer, identity.Subject)
+	if err==nil {
+		return userId, nil
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return 0, err
+	}
+	// never trust an email address the provider has not verified
+	if identity.Email=="" || !identity.EmailVerified {
+		return 0, errSSOEmailNotVerified
+	}
+	user, err := app.userByEmail(identity.Email)
+	switch {
+	case err==nil:
+		// someone may have signed up with the address without owning it
+		if !user.EmailVerified {
+			return 0, errSSOAccountNotVerified
+		}
+		userId = user.ID
+	case errors.Is(err, models.ErrNoRecord):
+		userId, err = app.provisionSSOUser(identity)
+		if err!=nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+	err = app.identities.Link(identity.Issuer, identity.Subject, userId)
+	if err!=nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+// create a user for an identity from the identity provider
+// the username is taken from the identity if it is valid and free, otherwise users can pick one later
+func (app *application) provisionSSOUser(identity *sso.Identity) (int, error) {
+	local, _, _ := strings.Cut(identity.Email, "@")
+	name := identity.Name
+	if name=="" {
+		name = local
+	}
+	candidate := ssoUsername(identity.PreferredUsername)
+	if candidate=="" {
+		candidate = ssoUsername(local)
+	}
+	usernames := []string{}
+	if candidate!="" {
+		usernames = append(usernames, candidate)
+		for i := 0; i < 3; i++ {
+			b := make([]byte, 2)
+			_, err := rand.Read(b)
+			if err!=nil {
+				return 0, err
+			}
+			usernames = append(usernames, fmt.Sprintf("%s-%s", candidate, hex.EncodeToString(b)))
+		}
+	}
+	usernames = append(usernames, "")
+	for _, username := range usernames {
+		id, err := app.users.InsertExternal(name, username, identity.Email, true)
+		if errors.Is(err, models.ErrDuplicateUsername) {
+			continue
+		}
+		return id, err
+	}
+	return 0, models.ErrDuplicateUsername
+}
+
+// turn a name from the identity provider into a valid username, or "" if that is not possible
+func ssoUsername(name string) string {
+	name = strings.ToLower(name)
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r=='-' || r=='_' {
+			return r
+		}
+		return '-'
+	}, name)
+	name = strings.Trim(name, "-_")
+	// leave room for a suffix
+	if len(name) > 25 {
+		name = strings.Trim(name[:25], "-_")
+	}
+	var v validator.Validator
+	validateUsername(&v, name)
+	if !v.Valid() {
+		return ""
+	}
+	return name
+}
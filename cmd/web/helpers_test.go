@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct{
+		name string
+		value string
+		start int
+		end int
+		ok bool
+	} {
+		{
+			name: "Single Line",
+			value: "12",
+			start: 12,
+			end: 12,
+			ok: true,
+		},
+		{
+			name: "Range",
+			value: "12-20",
+			start: 12,
+			end: 20,
+			ok: true,
+		},
+		{
+			name: "Fragment",
+			value: "L12-L20",
+			start: 12,
+			end: 20,
+			ok: true,
+		},
+		{
+			name: "Reversed Range",
+			value: "20-12",
+			start: 12,
+			end: 20,
+			ok: true,
+		},
+		{
+			name: "Zero",
+			value: "0-3",
+			ok: false,
+		},
+		{
+			name: "Invalid",
+			value: "twelve",
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := parseLineRange(tt.value)
+			assert.Equal(t, ok, tt.ok)
+			assert.Equal(t, start, tt.start)
+			assert.Equal(t, end, tt.end)
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	lines := splitLines("one\r\ntwo\r\nthree", 2, 3)
+	assert.Equal(t, len(lines), 3)
+	assert.Equal(t, lines[0].Text, "one")
+	assert.Equal(t, lines[0].Highlighted, false)
+	assert.Equal(t, lines[1].Text, "two")
+	assert.Equal(t, lines[1].Highlighted, true)
+	assert.Equal(t, lines[2].Number, 3)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err!=nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(codes), recoveryCodeCount)
+	assert.Equal(t, len(codes[0]), 11)
+	assert.Equal(t, hashes[0], hashRecoveryCode(codes[0]))
+	// users may type codes in upper case and without the dash
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))
+	assert.Equal(t, hashRecoveryCode(typed), hashes[0])
+}
+
+func TestSSOUsername(t *testing.T) {
+	tests := []struct{
+		name string
+		value string
+		want string
+	} {
+		{
+			name: "Valid",
+			value: "alice",
+			want: "alice",
+		},
+		{
+			name: "Upper Case",
+			value: "Alice_Cooper",
+			want: "alice_cooper",
+		},
+		{
+			name: "Invalid Characters",
+			value: "alice.cooper",
+			want: "alice-cooper",
+		},
+		{
+			name: "Trimmed",
+			value: ".alice.",
+			want: "alice",
+		},
+		{
+			name: "Too Short",
+			value: "al",
+			want: "",
+		},
+		{
+			name: "Reserved",
+			value: "Admin",
+			want: "",
+		},
+		{
+			name: "Too Long",
+			value: strings.Repeat("a", 40),
+			want: strings.Repeat("a", 25),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, ssoUsername(tt.value), tt.want)
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	app := &application{}
+	tests := []struct{
+		name string
+		role string
+		required string
+		want bool
+	} {
+		{
+			name: "Same Role",
+			role: "moderator",
+			required: "moderator",
+			want: true,
+		},
+		{
+			name: "More Privileged",
+			role: "admin",
+			required: "moderator",
+			want: true,
+		},
+		{
+			name: "Less Privileged",
+			role: "user",
+			required: "admin",
+			want: false,
+		},
+		{
+			name: "Not Authenticated",
+			role: "",
+			required: "user",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tt.role!="" {
+				r = r.WithContext(context.WithValue(r.Context(), userRoleContextKey, tt.role))
+			}
+			assert.Equal(t, app.hasRole(r, tt.required), tt.want)
+		})
+	}
+}
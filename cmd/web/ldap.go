@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"snippetbox.anukuljoshi/internals/directory"
+	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/sso"
+)
+
+// authenticates users against an LDAP directory
+// users are linked or provisioned like single sign-on users the first time they log in
+type ldapAuthenticator struct {
+	app *application
+	directory *directory.Directory
+	// maps lower case group DNs to roles, roles are not changed if empty
+	groupRoles map[string]string
+}
+
+func (a *ldapAuthenticator) Authenticate(email, password string) (int, error) {
+	entry, err := a.directory.Authenticate(email, password)
+	if err!=nil {
+		if errors.Is(err, directory.ErrInvalidCredentials) {
+			return 0, models.ErrInvalidCredentials
+		}
+		if errors.Is(err, directory.ErrAmbiguousUser) {
+			a.app.errorLog.Printf("WARNING: LDAP login %q matches more than one entry", email)
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	// the directory is managed by administrators, so its email addresses are trusted
+	userId, err := a.app.ssoUser(&sso.Identity{
+		Issuer: a.directory.Config.URL,
+		Subject: entry.DN,
+		Name: entry.Name,
+		PreferredUsername: entry.Username,
+		Email: entry.Email,
+		EmailVerified: true,
+	})
+	if err!=nil {
+		// let the next authenticator try, e.g. the local account with the email address
+		if errors.Is(err, errSSOEmailNotVerified) || errors.Is(err, errSSOAccountNotVerified) {
+			a.app.errorLog.Printf("WARNING: LDAP entry %s can not be logged in: %v", entry.DN, err)
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	// the directory decides the role of its users on every login
+	if len(a.groupRoles) > 0 {
+		err = a.app.users.SetRole(userId, groupRole(a.groupRoles, entry.Groups))
+		if err!=nil {
+			return 0, err
+		}
+	}
+	return userId, nil
+}
+
+// return the most privileged role any of the groups is mapped to
+func groupRole(groupRoles map[string]string, groups []string) string {
+	role := models.RoleUser
+	for _, group := range groups {
+		r, ok := groupRoles[strings.ToLower(group)]
+		if ok && models.RoleLevel(r) > models.RoleLevel(role) {
+			role = r
+		}
+	}
+	return role
+}
+
+// parse a list of role=groupDN pairs separated by semicolons
+// e.g. admin=cn=admins,ou=groups,dc=example,dc=com;moderator=cn=mods,ou=groups,dc=example,dc=com
+func parseGroupRoles(s string) (map[string]string, error) {
+	groupRoles := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair=="" {
+			continue
+		}
+		role, group, ok := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		group = strings.TrimSpace(group)
+		if !ok || group=="" {
+			return nil, fmt.Errorf("invalid group role %q, expected role=groupDN", pair)
+		}
+		if models.RoleLevel(role) < 0 {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		groupRoles[strings.ToLower(group)] = role
+	}
+	return groupRoles, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestGroupRole(t *testing.T) {
+	groupRoles, err := parseGroupRoles("admin=CN=Admins,OU=Groups,DC=example,DC=com; moderator=cn=mods,ou=groups,dc=example,dc=com")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	tests := []struct{
+		name string
+		groups []string
+		want string
+	} {
+		{
+			name: "No Groups",
+			want: "user",
+		},
+		{
+			name: "Unmapped Group",
+			groups: []string{"cn=staff,ou=groups,dc=example,dc=com"},
+			want: "user",
+		},
+		{
+			name: "Case Insensitive",
+			groups: []string{"cn=mods,ou=groups,dc=example,dc=com"},
+			want: "moderator",
+		},
+		{
+			name: "Most Privileged",
+			groups: []string{"cn=mods,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"},
+			want: "admin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, groupRole(groupRoles, tt.groups), tt.want)
+		})
+	}
+
+	_, err = parseGroupRoles("owner=cn=owners,dc=example,dc=com")
+	assert.Equal(t, err!=nil, true)
+}
@@ -6,34 +6,119 @@ import (
 	"flag"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
+	"snippetbox.anukuljoshi/internals/botcheck"
+	"snippetbox.anukuljoshi/internals/directory"
+	"snippetbox.anukuljoshi/internals/mailer"
 	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/passwords"
+	"snippetbox.anukuljoshi/internals/ratelimit"
+	"snippetbox.anukuljoshi/internals/sso"
+	"snippetbox.anukuljoshi/internals/tokens"
+	"snippetbox.anukuljoshi/internals/validator"
 )
 
 // Define an application struct to hold the application-wide dependencies
 type application struct {
 	debug bool
+	baseURL string
+	sessionLifetime time.Duration
+	rememberMeLifetime time.Duration
+	rememberMeIdle time.Duration
 	errorLog *log.Logger
 	infoLog *log.Logger
 	snippets *models.SnippetModel
 	users *models.UserModel
+	drafts *models.DraftModel
+	comments *models.CommentModel
+	stars *models.StarModel
+	collections *models.CollectionModel
+	resets *models.PasswordResetModel
+	recoveryCodes *models.RecoveryCodeModel
+	loginAttempts *models.LoginAttemptModel
+	userSessions *models.UserSessionModel
+	identities *models.IdentityModel
+	stats *models.StatsModel
+	reports *models.ReportModel
+	moderationLogs *models.ModerationLogModel
+	settings *models.SettingModel
+	// checks the email and password entered on the login form
+	authenticator models.Authenticator
 	templateCache map[string]*template.Template
 	formDecoder *form.Decoder
 	sessionManager *scs.SessionManager
+	tokens *tokens.Signer
+	mailer mailer.Mailer
+	breachList *validator.BreachList
+	botCheck *botcheck.Checker
+	// rate limits of groups of endpoints, nil if a limit is turned off
+	createLimiter ratelimit.Store
+	loginLimiter ratelimit.Store
+	apiLimiter ratelimit.Store
+	rawLimiter ratelimit.Store
+	previewLimiter ratelimit.Store
+	strengthLimiter ratelimit.Store
+	sso *sso.Provider
+	proxyAuth *proxyAuth
+	// finds the client address behind trusted proxies, nil if there are none
+	ipResolver *ipResolver
 }
 
 func main() {
 	// define a new command line flag "addr" to specify to host address
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	debug := flag.Bool("debug", false, "Enable debug mode")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Public URL of the application, used in links sent by email")
+	mailerBackend := flag.String("mailer", "stdout", "Mailer backend: smtp, file or stdout")
+	mailFile := flag.String("mail-file", "mail.log", "File the file mailer appends emails to")
+	smtpHost := flag.String("smtp-host", "localhost", "SMTP server host")
+	smtpPort := flag.Int("smtp-port", 25, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username, the password is read from SMTP_PASSWORD")
+	smtpSender := flag.String("smtp-sender", "SnippetBox <no-reply@snippetbox.local>", "Sender of emails")
+	sessionLifetime := flag.Duration("session-lifetime", 12*time.Hour, "How long a login lasts without remember me")
+	rememberMeLifetime := flag.Duration("remember-me-lifetime", 30*24*time.Hour, "How long a remembered login lasts")
+	rememberMeIdle := flag.Duration("remember-me-idle", 7*24*time.Hour, "How long a remembered login lasts without being used")
+	argon2Time := flag.Uint("argon2-time", uint(passwords.DefaultParams.Time), "Number of argon2id passes over memory when hashing passwords")
+	argon2Memory := flag.Uint("argon2-memory", uint(passwords.DefaultParams.Memory), "Memory in KiB used by argon2id when hashing passwords")
+	argon2Threads := flag.Uint("argon2-threads", uint(passwords.DefaultParams.Threads), "Number of threads used by argon2id when hashing passwords")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL for single sign-on, disabled if empty; the client secret is read from OIDC_CLIENT_SECRET")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcName := flag.String("oidc-name", "SSO", "Name of the identity provider shown on the login page")
+	authOrder := flag.String("auth-order", "local", "Comma separated list of where passwords are checked, in order: local, ldap")
+	ldapURL := flag.String("ldap-url", "", "LDAP server URL, e.g. ldaps://ldap.example.com:636")
+	ldapStartTLS := flag.Bool("ldap-start-tls", false, "Upgrade ldap:// connections with StartTLS")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "DN used to search the directory, anonymous if empty; the password is read from LDAP_BIND_PASSWORD")
+	ldapBaseDN := flag.String("ldap-base-dn", "", "Base DN users are searched under")
+	ldapUserFilter := flag.String("ldap-user-filter", "(&(objectClass=person)(mail=%s))", "Filter which finds a user, %s is replaced by the email address entered on the login form")
+	ldapGroupBaseDN := flag.String("ldap-group-base-dn", "", "Base DN groups are searched under, defaults to the base DN")
+	ldapGroupFilter := flag.String("ldap-group-filter", "", "Filter which finds the groups of a user, %s is replaced by the user's DN; the memberOf attribute is used if empty")
+	ldapGroupRoles := flag.String("ldap-group-roles", "", "Semicolon separated role=groupDN pairs which give members of the group the role")
+	proxyAuthHeader := flag.String("proxy-auth-header", "", "Header an authenticating proxy puts the user name in, e.g. X-Forwarded-User; turns off password logins and signups, disabled if empty")
+	proxyAuthEmailHeader := flag.String("proxy-auth-email-header", "", "Header with the user's email address, the user name is used as email address if empty")
+	proxyAuthCIDRs := flag.String("proxy-auth-cidrs", "", "Comma separated CIDRs of the authenticating proxies the headers are trusted from")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated CIDRs of reverse proxies, e.g. load balancers, whose forwarding header gives the client address")
+	clientIPHeader := flag.String("client-ip-header", "X-Forwarded-For", "Header the trusted proxies put the client address in: X-Forwarded-For or Forwarded")
+	breachedPasswords := flag.String("breached-passwords", "", "File of breached password hashes built with cmd/breachlist, disabled if empty")
+	powDifficulty := flag.Int("pow-difficulty", 0, "Leading zero bits of the proof of work browsers solve before signing up, 0 turns it off; each bit doubles the work")
+	rateLimitStore := flag.String("rate-limit-store", "memory", "Where rate limit buckets are kept: memory, or mysql to share them between several instances")
+	rateLimitCreate := flag.String("rate-limit-create", "30/h", "Snippets a user can create, as requests per s, m or h; 0 turns the limit off")
+	rateLimitLogin := flag.String("rate-limit-login", "20/m", "Login, signup and password reset attempts from a client, as requests per s, m or h; 0 turns the limit off")
+	rateLimitAPI := flag.String("rate-limit-api", "300/m", "Requests a client makes to the endpoints used by scripts, such as autosave and previews; 0 turns the limit off")
+	rateLimitRaw := flag.String("rate-limit-raw", "120/m", "Raw snippets a client can download, as requests per s, m or h; 0 turns the limit off")
+	rateLimitPreview := flag.String("rate-limit-preview", "120/m", "Previews a client can render while editing, on top of the api limit, as requests per s, m or h; 0 turns the limit off")
+	rateLimitStrength := flag.String("rate-limit-strength", "300/m", "Password strength checks a client can make, on top of the api limit, as requests per s, m or h; 0 turns the limit off")
+	formMinDelay := flag.Duration("form-min-delay", 3*time.Second, "Forms for anonymous users submitted sooner than this after being shown are taken to be from bots")
 	flag.Parse()
 
 	// create a new logger for info messages
@@ -46,6 +131,11 @@ func main() {
 		errorLog.Fatal(err)
 	}
 	dsn := os.Getenv("MYSQL_DSN")
+	// secret key used to sign tokens in links such as email verification links
+	secretKey := os.Getenv("SECRET_KEY")
+	if secretKey=="" {
+		errorLog.Fatal("SECRET_KEY must be set")
+	}
 
 	db, err := openDB(dsn)
 	if err!=nil {
@@ -53,12 +143,64 @@ func main() {
 	}
 	defer db.Close()
 
+	// initialize the mailer backend
+	// the file and stdout backends only write emails out for development
+	var mail mailer.Mailer
+	switch *mailerBackend {
+	case "smtp":
+		mail = &mailer.SMTPMailer{
+			Host: *smtpHost,
+			Port: *smtpPort,
+			Username: *smtpUsername,
+			Password: os.Getenv("SMTP_PASSWORD"),
+			Sender: *smtpSender,
+		}
+	case "file":
+		mailLog, err := os.OpenFile(*mailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err!=nil {
+			errorLog.Fatal(err)
+		}
+		defer mailLog.Close()
+		mail = mailer.NewWriterMailer(mailLog)
+	case "stdout":
+		mail = mailer.NewWriterMailer(os.Stdout)
+	default:
+		errorLog.Fatalf("unknown mailer backend %q", *mailerBackend)
+	}
+
+	// optionally reject passwords which appeared in data breaches
+	var breachList *validator.BreachList
+	if *breachedPasswords!="" {
+		breachList, err = validator.OpenBreachList(*breachedPasswords)
+		if err!=nil {
+			errorLog.Fatal(err)
+		}
+		defer breachList.Close()
+		infoLog.Printf("loaded %d breached password hashes", breachList.Len())
+	}
+
 	// initialize a template cache
 	templateCache, err := newTemplateCache()
 	if err!=nil {
 		errorLog.Fatal(err)
 	}
 
+	// passwords are rehashed with these params the next time their owner logs in
+	// argon2 panics on zero passes or threads and the flags would wrap when converted
+	if *argon2Time < 1 || uint64(*argon2Time) > math.MaxUint32 {
+		errorLog.Fatalf("-argon2-time must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	if *argon2Memory < 1 || uint64(*argon2Memory) > math.MaxUint32 {
+		errorLog.Fatalf("-argon2-memory must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	if *argon2Threads < 1 || *argon2Threads > math.MaxUint8 {
+		errorLog.Fatalf("-argon2-threads must be between 1 and %d", math.MaxUint8)
+	}
+	passwordParams := passwords.DefaultParams
+	passwordParams.Time = uint32(*argon2Time)
+	passwordParams.Memory = uint32(*argon2Memory)
+	passwordParams.Threads = uint8(*argon2Threads)
+
 	// initialize form decoder instance
 	formDecoder := form.NewDecoder()
 
@@ -66,22 +208,153 @@ func main() {
 	var sessionManager = scs.New()
 	// use mysql as db
 	sessionManager.Store = mysqlstore.New(db)
-	// set Lifetime to 12 hours, session automatically expires after 12 hours
-	sessionManager.Lifetime = 12 * time.Hour
+	// anonymous sessions and logins without remember me last sessionLifetime
+	// remembered logins get a longer deadline with their own idle timeout, see extendRememberedLogin
+	sessionManager.Lifetime = *sessionLifetime
+	// use browser session cookies unless the user asked to be remembered
+	sessionManager.Cookie.Persist = false
 	// set Secure attribute to send Cookie only in https connection
 	sessionManager.Cookie.Secure = true
 
 	// initialize an application struct with dependencies
 	app := &application{
 		debug: *debug,
+		baseURL: strings.TrimSuffix(*baseURL, "/"),
+		sessionLifetime: *sessionLifetime,
+		rememberMeLifetime: *rememberMeLifetime,
+		rememberMeIdle: *rememberMeIdle,
 		errorLog: errorLog,
 		infoLog: infoLog,
 		snippets: &models.SnippetModel{DB: db},
-		users: &models.UserModel{DB: db},
+		users: &models.UserModel{DB: db, Hasher: passwords.New(passwordParams)},
+		drafts: &models.DraftModel{DB: db},
+		comments: &models.CommentModel{DB: db},
+		stars: &models.StarModel{DB: db},
+		collections: &models.CollectionModel{DB: db},
+		resets: &models.PasswordResetModel{DB: db},
+		recoveryCodes: &models.RecoveryCodeModel{DB: db},
+		loginAttempts: &models.LoginAttemptModel{DB: db},
+		userSessions: &models.UserSessionModel{DB: db},
+		identities: &models.IdentityModel{DB: db},
+		stats: &models.StatsModel{DB: db},
+		reports: &models.ReportModel{DB: db},
+		moderationLogs: &models.ModerationLogModel{DB: db},
+		settings: &models.SettingModel{DB: db},
 		templateCache: templateCache,
 		formDecoder: formDecoder,
 		sessionManager: sessionManager,
+		tokens: tokens.NewSigner([]byte(secretKey)),
+		mailer: mail,
+		breachList: breachList,
+	}
+
+	// rate limits are kept in memory unless several instances have to share them
+	if *rateLimitStore!="memory" && *rateLimitStore!="mysql" {
+		errorLog.Fatalf("unknown rate limit store %q", *rateLimitStore)
+	}
+	newLimiter := func(name, limit string) ratelimit.Store {
+		rate, burst, err := ratelimit.ParseRate(limit)
+		if err!=nil {
+			errorLog.Fatalf("-rate-limit-%s: %v", name, err)
+		}
+		if burst==0 {
+			return nil
+		}
+		if *rateLimitStore=="mysql" {
+			return ratelimit.NewMySQLStore(db, name, rate, burst)
+		}
+		return ratelimit.New(rate, burst)
 	}
+	app.createLimiter = newLimiter("create", *rateLimitCreate)
+	app.loginLimiter = newLimiter("login", *rateLimitLogin)
+	app.apiLimiter = newLimiter("api", *rateLimitAPI)
+	app.rawLimiter = newLimiter("raw", *rateLimitRaw)
+	app.previewLimiter = newLimiter("preview", *rateLimitPreview)
+	app.strengthLimiter = newLimiter("strength", *rateLimitStrength)
+
+	// keep bots away from the forms of anonymous users such as signup
+	if *powDifficulty < 0 || *powDifficulty > botcheck.MaxDifficulty {
+		errorLog.Fatalf("-pow-difficulty must be between 0 and %d", botcheck.MaxDifficulty)
+	}
+	app.botCheck = botcheck.New(app.tokens, *powDifficulty)
+	app.botCheck.MinDelay = *formMinDelay
+
+	// single sign-on through an OpenID Connect provider
+	if *oidcIssuer!="" {
+		app.sso = sso.New(sso.Config{
+			Name: *oidcName,
+			Issuer: *oidcIssuer,
+			ClientID: *oidcClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL: app.baseURL + "/user/login/sso/callback",
+		})
+	}
+
+	// find the address of clients behind reverse proxies
+	if *trustedProxies!="" {
+		trusted, err := parseCIDRs(*trustedProxies)
+		if err!=nil {
+			errorLog.Fatal(err)
+		}
+		header := http.CanonicalHeaderKey(*clientIPHeader)
+		if header!="X-Forwarded-For" && header!="Forwarded" {
+			errorLog.Fatal("-client-ip-header must be X-Forwarded-For or Forwarded")
+		}
+		app.ipResolver = &ipResolver{header: header, trusted: trusted}
+	}
+
+	// log users in with the headers of an authenticating reverse proxy
+	if *proxyAuthHeader!="" {
+		trusted, err := parseCIDRs(*proxyAuthCIDRs)
+		if err!=nil {
+			errorLog.Fatal(err)
+		}
+		if len(trusted)==0 {
+			errorLog.Fatal("-proxy-auth-cidrs must be set to trust -proxy-auth-header")
+		}
+		app.proxyAuth = &proxyAuth{
+			header: *proxyAuthHeader,
+			emailHeader: *proxyAuthEmailHeader,
+			trusted: trusted,
+		}
+	}
+
+	// password logins are checked by each authenticator in order until one accepts them
+	var authenticators models.AuthenticatorChain
+	for _, name := range strings.Split(*authOrder, ",") {
+		switch strings.TrimSpace(name) {
+		case "local":
+			authenticators = append(authenticators, app.users)
+		case "ldap":
+			if *ldapURL=="" {
+				errorLog.Fatal("-ldap-url must be set to check passwords with LDAP")
+			}
+			groupRoles, err := parseGroupRoles(*ldapGroupRoles)
+			if err!=nil {
+				errorLog.Fatal(err)
+			}
+			authenticators = append(authenticators, &ldapAuthenticator{
+				app: app,
+				directory: directory.New(directory.Config{
+					URL: *ldapURL,
+					StartTLS: *ldapStartTLS,
+					BindDN: *ldapBindDN,
+					BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+					BaseDN: *ldapBaseDN,
+					UserFilter: *ldapUserFilter,
+					GroupBaseDN: *ldapGroupBaseDN,
+					GroupFilter: *ldapGroupFilter,
+				}),
+				groupRoles: groupRoles,
+			})
+		default:
+			errorLog.Fatalf("unknown authenticator %q", name)
+		}
+	}
+	app.authenticator = authenticators
+
+	// delete drafts which have not been touched in a while in the background
+	go app.deleteExpiredDrafts(time.Hour)
 
 	// initialize a tls.Config struct to hold non-default TLS settings
 	var tlsConfig = &tls.Config{
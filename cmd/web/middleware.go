@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
+	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/ratelimit"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -34,9 +40,22 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// store the address of the client in the request context for clientIP
+// requests through trusted proxies get the address the proxies forwarded
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if app.ipResolver!=nil {
+			ip = app.ipResolver.resolve(r)
+		}
+		ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		app.infoLog.Printf("%s - %s %s %s", clientIP(r), r.Proto, r.Method, r.URL.RequestURI())
 		next.ServeHTTP(w, r)
 	})
 }
@@ -59,6 +78,11 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
+			// there is no login page to send the user to
+			if app.proxyAuth!=nil {
+				app.clientError(w, http.StatusUnauthorized)
+				return
+			}
 			// add path user is trying to access to session data before redirecting
 			app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.Path)
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -70,6 +94,64 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// returns a middleware which responds with 403 Forbidden unless the user has role
+// or a more privileged one, must be used after requireAuthentication
+func (app *application) requireRole(role string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.hasRole(r, role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireVerifiedEmail sends users who have not verified their email address to the account page
+// must be used after requireAuthentication
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
+		verified, err := app.users.EmailVerified(id)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		if !verified {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address before creating snippets")
+			http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// returns a middleware which rejects requests with 429 Too Many Requests
+// once the client has used up its tokens in limiter
+// the Retry-After header tells the client when it can try again
+// a nil limiter does not limit anything
+func (app *application) rateLimit(limiter ratelimit.Store) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		if limiter==nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := limiter.Take(app.rateLimitKey(r))
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				app.clientError(w, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) noSurf(next http.Handler) http.Handler {
 	var csrfHandler = nosurf.New(next)
 	csrfHandler.SetBaseCookie(
@@ -84,6 +166,19 @@ func (app *application) noSurf(next http.Handler) http.Handler {
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// behind an authenticating proxy its header decides who is logged in
+		if app.proxyAuth!=nil {
+			err := app.proxyLogIn(r)
+			if err!=nil {
+				if errors.Is(err, errProxyNoEmail) || errors.Is(err, errSSOAccountNotVerified) || errors.Is(err, errAccountDisabled) {
+					app.errorLog.Printf("WARNING: proxy user %q can not be logged in: %v", r.Header.Get(app.proxyAuth.header), err)
+					app.clientError(w, http.StatusForbidden)
+					return
+				}
+				app.serverError(w, err)
+				return
+			}
+		}
 		// get authenticatedUserId from session
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
 		if id==0 {
@@ -91,18 +186,47 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		// check if user exists with id
-		exists, err := app.users.Exists(id)
+		// check if user exists with id and the session has not been revoked
+		// this is the only query made for most requests, so it reads everything needed at once
+		info, err := app.users.SessionInfo(id)
 		if err!=nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			app.serverError(w, err)
 			return
 		}
-		// if users exists
-		// create copy of request with context containing isAuthenticatedContextKey set to true
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, exists)
-			r = r.WithContext(ctx)
+		if info.Epoch!=app.sessionManager.GetInt(r.Context(), "sessionEpoch") || info.Disabled {
+			// the user was logged out everywhere, e.g. after a password reset
+			app.sessionManager.Remove(r.Context(), "authenticatedUserId")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if app.loginExpired(r) {
+			err = app.userSessions.Delete(app.sessionManager.Token(r.Context()))
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
+			err = app.sessionManager.Destroy(r.Context())
+			if err!=nil {
+				app.serverError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
 		}
+		// keep the device list on the account page up to date
+		err = app.touchSession(r)
+		if err!=nil {
+			app.serverError(w, err)
+			return
+		}
+		// create copy of request with context containing isAuthenticatedContextKey set to true
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, userRoleContextKey, info.Role)
+		r = r.WithContext(ctx)
 		// call next handler
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"snippetbox.anukuljoshi/internals/sso"
+	"snippetbox.anukuljoshi/internals/validator"
+)
+
+// proxyAuth describes an authenticating reverse proxy which tells us who the user is in a header
+type proxyAuth struct {
+	header string
+	// header with the user's email address, the user name is used if empty
+	emailHeader string
+	// addresses of the proxies, the headers of other clients are ignored
+	trusted []*net.IPNet
+}
+
+// identities of users logged in by the proxy are linked with this issuer
+const proxyIssuer = "proxy"
+
+var errProxyNoEmail = errors.New("proxy did not send a valid email address")
+
+// check if the request comes straight from a trusted proxy
+// this has to look at the address of the connection, never at forwarding headers
+func (p *proxyAuth) trusts(r *http.Request) bool {
+	ip := net.ParseIP(remoteIP(r))
+	if ip==nil {
+		return false
+	}
+	return containsIP(p.trusted, ip)
+}
+
+// log in the user named in the proxy's header, or log out if there is none
+// users the app has not seen before are linked or provisioned like single sign-on users
+func (app *application) proxyLogIn(r *http.Request) error {
+	user := ""
+	if app.proxyAuth.trusts(r) {
+		user = strings.TrimSpace(r.Header.Get(app.proxyAuth.header))
+	}
+	if user=="" {
+		app.sessionManager.Remove(r.Context(), "authenticatedUserId")
+		app.sessionManager.Remove(r.Context(), "proxyUser")
+		return nil
+	}
+	// the session already belongs to the user
+	if app.sessionManager.GetInt(r.Context(), "authenticatedUserId")!=0 && app.sessionManager.GetString(r.Context(), "proxyUser")==user {
+		return nil
+	}
+	email := user
+	if app.proxyAuth.emailHeader!="" {
+		email = strings.TrimSpace(r.Header.Get(app.proxyAuth.emailHeader))
+	}
+	if !validator.Matches(email, validator.EmailRX) {
+		return errProxyNoEmail
+	}
+	// the proxy has authenticated the user, so its email addresses are trusted
+	userId, err := app.ssoUser(&sso.Identity{
+		Issuer: proxyIssuer,
+		Subject: user,
+		PreferredUsername: user,
+		Email: email,
+		EmailVerified: true,
+	})
+	if err!=nil {
+		return err
+	}
+	// the proxy decides how long users stay logged in
+	err = app.logIn(r, userId, true)
+	if err!=nil {
+		return err
+	}
+	app.sessionManager.Put(r.Context(), "proxyUser", user)
+	return nil
+}
+
+// parse a comma separated list of CIDRs, single addresses are treated as /32 or /128
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr=="" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip==nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 128
+			if ip.To4()!=nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err!=nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestProxyAuthTrusts(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8, 192.168.1.5, ::1")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	p := &proxyAuth{header: "X-Forwarded-User", trusted: trusted}
+	tests := []struct{
+		name string
+		remoteAddr string
+		want bool
+	} {
+		{
+			name: "In Range",
+			remoteAddr: "10.1.2.3:51234",
+			want: true,
+		},
+		{
+			name: "Single Address",
+			remoteAddr: "192.168.1.5:51234",
+			want: true,
+		},
+		{
+			name: "IPv6",
+			remoteAddr: "[::1]:51234",
+			want: true,
+		},
+		{
+			name: "Untrusted",
+			remoteAddr: "192.168.1.6:51234",
+			want: false,
+		},
+		{
+			name: "Invalid",
+			remoteAddr: "proxy",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			// forwarding headers must not make a client trusted
+			r.Header.Set("X-Forwarded-For", "10.1.2.3")
+			assert.Equal(t, p.trusts(r), tt.want)
+		})
+	}
+
+	_, err = parseCIDRs("10.0.0.0/33")
+	assert.Equal(t, err!=nil, true)
+}
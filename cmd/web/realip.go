@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// return the IP address of the client which made the request
+// this is the address resolved by the realIP middleware, which looks behind trusted proxies
+func clientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// return the IP address of the connection, which may be a proxy
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err!=nil {
+		ip = r.RemoteAddr
+	}
+	return ip
+}
+
+// resolves the address of the client from the header set by trusted reverse proxies
+// each proxy appends the address it received the request from, so the header is
+// read from right to left while the address read last belongs to a trusted proxy
+// anything further left was sent by the client and can not be trusted
+type ipResolver struct {
+	// X-Forwarded-For or Forwarded, only the header the proxies set is read
+	// as clients can send either
+	header string
+	trusted []*net.IPNet
+}
+
+func (c *ipResolver) resolve(r *http.Request) string {
+	ip := net.ParseIP(remoteIP(r))
+	if ip==nil {
+		return remoteIP(r)
+	}
+	var hops []string
+	if c.header=="Forwarded" {
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		for _, value := range r.Header.Values(c.header) {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	}
+	for i := len(hops) - 1; i >= 0 && containsIP(c.trusted, ip); i-- {
+		hop := parseHop(hops[i])
+		// stop at addresses a proxy hid, such as unknown, and at garbage
+		if hop==nil {
+			break
+		}
+		ip = hop
+	}
+	return ip.String()
+}
+
+// return the for= address of each element of Forwarded headers, "" if an element has none
+// e.g. for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parse an address from a forwarding header, which may have a port and IPv6 brackets
+// returns nil if it is not an IP address
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if ip := net.ParseIP(hop); ip!=nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(hop)
+	if err!=nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestIPResolver(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	tests := []struct{
+		name string
+		header string
+		remoteAddr string
+		xForwardedFor []string
+		forwarded []string
+		want string
+	} {
+		{
+			name: "Untrusted Peer",
+			header: "X-Forwarded-For",
+			remoteAddr: "203.0.113.7:51234",
+			xForwardedFor: []string{"198.51.100.1"},
+			want: "203.0.113.7",
+		},
+		{
+			name: "No Header",
+			header: "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:51234",
+			want: "10.0.0.1",
+		},
+		{
+			name: "Trusted Peer",
+			header: "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:51234",
+			xForwardedFor: []string{"198.51.100.1"},
+			want: "198.51.100.1",
+		},
+		{
+			name: "Spoofed Entries",
+			header: "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:51234",
+			xForwardedFor: []string{"127.0.0.1, 10.9.9.9, 198.51.100.1"},
+			want: "198.51.100.1",
+		},
+		{
+			name: "Proxy Chain",
+			header: "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:51234",
+			xForwardedFor: []string{"198.51.100.1", "10.0.0.2"},
+			want: "198.51.100.1",
+		},
+		{
+			name: "Invalid Entry",
+			header: "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:51234",
+			xForwardedFor: []string{"198.51.100.1, unknown"},
+			want: "10.0.0.1",
+		},
+		{
+			name: "Forwarded",
+			header: "Forwarded",
+			remoteAddr: "10.0.0.1:51234",
+			forwarded: []string{`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`},
+			want: "2001:db8::1",
+		},
+		{
+			name: "Forwarded Ignores X-Forwarded-For",
+			header: "Forwarded",
+			remoteAddr: "10.0.0.1:51234",
+			xForwardedFor: []string{"198.51.100.1"},
+			forwarded: []string{"for=192.0.2.60;proto=https"},
+			want: "192.0.2.60",
+		},
+		{
+			name: "Forwarded Obfuscated",
+			header: "Forwarded",
+			remoteAddr: "10.0.0.1:51234",
+			forwarded: []string{"for=_hidden"},
+			want: "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ipResolver{header: tt.header, trusted: trusted}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			for _, value := range tt.forwarded {
+				r.Header.Add("Forwarded", value)
+			}
+			assert.Equal(t, c.resolve(r), tt.want)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
+	"snippetbox.anukuljoshi/internals/models"
 	"snippetbox.anukuljoshi/ui"
 )
 
@@ -31,23 +32,98 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.viewSnippet))
-	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignUp))
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignUpPost))
-	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/snippet/raw/:id", dynamic.Append(app.rateLimit(app.rawLimiter)).ThenFunc(app.rawSnippet))
+	router.Handler(http.MethodGet, "/collection/view/:id", dynamic.ThenFunc(app.viewCollection))
+	router.Handler(http.MethodGet, "/collection/share/:token", dynamic.ThenFunc(app.viewSharedCollection))
+	router.Handler(http.MethodGet, "/u/:username", dynamic.ThenFunc(app.userProfile))
+	// users behind an authenticating proxy are logged in by the proxy
+	if app.proxyAuth==nil {
+		// attempts to log in, sign up or reset a password share a rate limit
+		var login = dynamic.Append(app.rateLimit(app.loginLimiter))
+		router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignUp))
+		router.Handler(http.MethodPost, "/user/signup", login.ThenFunc(app.userSignUpPost))
+		router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
+		router.Handler(http.MethodPost, "/user/login", login.ThenFunc(app.userLoginPost))
+		router.Handler(http.MethodGet, "/user/login/sso", dynamic.ThenFunc(app.ssoLogin))
+		router.Handler(http.MethodGet, "/user/login/sso/callback", dynamic.ThenFunc(app.ssoCallback))
+		router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+		router.Handler(http.MethodPost, "/user/login/2fa", login.ThenFunc(app.userLoginTwoFactorPost))
+		router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.forgotPassword))
+		router.Handler(http.MethodPost, "/user/password/reset", login.ThenFunc(app.forgotPasswordPost))
+		router.Handler(http.MethodGet, "/user/password/reset/confirm", dynamic.ThenFunc(app.resetPassword))
+		router.Handler(http.MethodPost, "/user/password/reset/confirm", login.ThenFunc(app.resetPasswordPost))
+	}
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.verifyEmail))
+	router.Handler(http.MethodPost, "/user/password/strength", dynamic.Append(app.rateLimit(app.apiLimiter), app.rateLimit(app.strengthLimiter)).ThenFunc(app.passwordStrengthPost))
+	router.Handler(http.MethodGet, "/user/email/verify", dynamic.ThenFunc(app.verifyEmailChange))
 
 	var protected = dynamic.Append(app.requireAuthentication)
 	// protected routes
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.createSnippet))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.createSnippetPost))
+	router.Handler(http.MethodPost, "/snippet/draft/delete/:id", protected.ThenFunc(app.deleteDraftPost))
+	router.Handler(http.MethodPost, "/snippet/comment/:id", protected.ThenFunc(app.commentSnippetPost))
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.starSnippetPost))
+	router.Handler(http.MethodGet, "/comment/edit/:id", protected.ThenFunc(app.editComment))
+	router.Handler(http.MethodPost, "/comment/edit/:id", protected.ThenFunc(app.editCommentPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.deleteCommentPost))
 	router.Handler(http.MethodGet, "/user/account", protected.ThenFunc(app.userAccount))
+	router.Handler(http.MethodGet, "/user/starred", protected.ThenFunc(app.userStarred))
+	router.Handler(http.MethodGet, "/user/collection/create", protected.ThenFunc(app.createCollection))
+	router.Handler(http.MethodPost, "/user/collection/create", protected.ThenFunc(app.createCollectionPost))
+	router.Handler(http.MethodGet, "/user/collection/edit/:id", protected.ThenFunc(app.editCollection))
+	router.Handler(http.MethodPost, "/user/collection/edit/:id", protected.ThenFunc(app.editCollectionPost))
+	router.Handler(http.MethodPost, "/user/collection/delete/:id", protected.ThenFunc(app.deleteCollectionPost))
+	router.Handler(http.MethodPost, "/user/collection/move/:id", protected.ThenFunc(app.moveCollectionPost))
+	router.Handler(http.MethodPost, "/user/collection/add", protected.ThenFunc(app.addToCollectionPost))
+	router.Handler(http.MethodPost, "/user/collection/remove/:id", protected.ThenFunc(app.removeFromCollectionPost))
+	router.Handler(http.MethodPost, "/user/collection/reorder/:id", protected.ThenFunc(app.moveInCollectionPost))
+	router.Handler(http.MethodGet, "/user/profile/update", protected.ThenFunc(app.updateProfile))
+	// checks the current password, so it shares the rate limit of the login forms
+	router.Handler(http.MethodPost, "/user/profile/update", protected.Append(app.rateLimit(app.loginLimiter)).ThenFunc(app.updateProfilePost))
 	router.Handler(http.MethodGet, "/user/password/update", protected.ThenFunc(app.updatePassword))
 	router.Handler(http.MethodPost, "/user/password/update", protected.ThenFunc(app.updatePasswordPost))
+	router.Handler(http.MethodGet, "/user/2fa", protected.ThenFunc(app.manageTwoFactor))
+	router.Handler(http.MethodPost, "/user/2fa", protected.ThenFunc(app.manageTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/2fa/setup", protected.ThenFunc(app.setupTwoFactor))
+	router.Handler(http.MethodPost, "/user/2fa/setup", protected.ThenFunc(app.setupTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/2fa/qr", protected.ThenFunc(app.twoFactorQRCode))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodPost, "/user/sessions/revoke/:id", protected.ThenFunc(app.revokeSessionPost))
+	router.Handler(http.MethodPost, "/user/sessions/revoke-others", protected.ThenFunc(app.revokeOtherSessionsPost))
+	// sends an email, so it shares the rate limit of the login forms
+	router.Handler(http.MethodPost, "/user/verify/resend", protected.Append(app.rateLimit(app.loginLimiter)).ThenFunc(app.resendVerificationPost))
+	router.Handler(http.MethodGet, "/snippet/report/:id", protected.ThenFunc(app.reportSnippet))
+	router.Handler(http.MethodPost, "/snippet/report/:id", protected.ThenFunc(app.reportSnippetPost))
+
+	// snippets can only be created once the user has verified their email address
+	var verified = protected.Append(app.requireVerifiedEmail)
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.createSnippet))
+	router.Handler(http.MethodPost, "/snippet/create", verified.Append(app.rateLimit(app.createLimiter)).ThenFunc(app.createSnippetPost))
+	// endpoints called by scripts on the create page share the api rate limit
+	var api = verified.Append(app.rateLimit(app.apiLimiter))
+	router.Handler(http.MethodPost, "/snippet/draft", api.ThenFunc(app.saveDraftPost))
+	router.Handler(http.MethodPost, "/snippet/preview", api.Append(app.rateLimit(app.previewLimiter)).ThenFunc(app.previewSnippetPost))
+
+	// the moderation queue is open to moderators and admins
+	var moderator = protected.Append(app.requireRole(models.RoleModerator))
+	router.Handler(http.MethodGet, "/moderation", moderator.ThenFunc(app.moderationQueue))
+	router.Handler(http.MethodGet, "/moderation/log", moderator.ThenFunc(app.moderationLog))
+	router.Handler(http.MethodGet, "/moderation/snippet/:id", moderator.ThenFunc(app.moderationReview))
+	router.Handler(http.MethodPost, "/moderation/snippet/:id", moderator.ThenFunc(app.moderationReviewPost))
+
+	// the admin area is only open to admins
+	var admin = protected.Append(app.requireRole(models.RoleAdmin))
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/role/:id", admin.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodPost, "/admin/users/status/:id", admin.ThenFunc(app.adminUserStatusPost))
+	router.Handler(http.MethodGet, "/admin/snippets", admin.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodPost, "/admin/snippets/delete/:id", admin.ThenFunc(app.adminDeleteSnippetPost))
+	router.Handler(http.MethodGet, "/admin/settings", admin.ThenFunc(app.adminSettings))
+	router.Handler(http.MethodPost, "/admin/settings", admin.ThenFunc(app.adminSettingsPost))
 
 	// middleware chain with our standard middlewares
 	// which will be used for every request
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	standard := alice.New(app.recoverPanic, app.realIP, app.logRequest, secureHeaders)
 
 	// return standard middleware chain followed by router
 	return standard.Then(router)
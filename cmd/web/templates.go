@@ -1,14 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"html/template"
 	"io/fs"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/justinas/nosurf"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"snippetbox.anukuljoshi/internals/botcheck"
 	"snippetbox.anukuljoshi/internals/models"
+	"snippetbox.anukuljoshi/internals/secrets"
+	"snippetbox.anukuljoshi/internals/validator"
 	"snippetbox.anukuljoshi/ui"
 )
 
@@ -16,7 +27,50 @@ type templateData struct {
 	CurrentYear int
 	Snippet *models.Snippet
 	Snippets []*models.Snippet
+	Lines []snippetLine
+	// content of a markdown snippet rendered as html, empty for code
+	Markdown template.HTML
+	// open the source of a markdown snippet, e.g. to show comments on its lines
+	ShowSource bool
+	Comment *models.Comment
+	Comments []commentView
+	Starred bool
+	Collection *models.Collection
+	Collections []*models.Collection
+	IsOwner bool
+	Tab string
+	Drafts []*models.Draft
 	User *models.User
+	Profile *models.Profile
+	TwoFactorSecret string
+	RecoveryCodes []string
+	RecoveryCodesLeft int
+	Sessions []sessionView
+	SSOName string
+	// admin area
+	Stats *models.Stats
+	Users []*models.User
+	AuthoredSnippets []*models.AuthoredSnippet
+	Roles []string
+	Filter any
+	// links to the previous and next page of a list, empty if there is none
+	PrevPageURL string
+	NextPageURL string
+	IsAdmin bool
+	// moderation
+	Reports []*models.Report
+	ReportedSnippets []*models.ReportedSnippet
+	ReportCategories []string
+	ModerationLog []*models.ModerationEntry
+	IsModerator bool
+	// secrets found in a snippet before it is saved
+	SecretFindings []secrets.Finding
+	// snippets with secrets are not saved, so the author can only remove them
+	BlockSecrets bool
+	// hidden challenge for forms of anonymous users, see botcheck.tmpl.html
+	BotCheck *botcheck.Challenge
+	// users are logged in by an authenticating proxy instead of a password
+	ProxyAuth bool
 	Form any
 	Flash any
 	IsAuthenticated bool
@@ -24,13 +78,20 @@ type templateData struct {
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
-	return &templateData{
+	data := &templateData{
 		CurrentYear: time.Now().Year(),
 		// add flash message if it exists
 		Flash: app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken: nosurf.Token(r),
+		ProxyAuth: app.proxyAuth!=nil,
+		IsAdmin: app.hasRole(r, models.RoleAdmin),
+		IsModerator: app.hasRole(r, models.RoleModerator),
 	}
+	if app.sso!=nil {
+		data.SSOName = app.sso.Config.Name
+	}
+	return data
 }
 
 // convert time.Time to human readable format
@@ -41,10 +102,140 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// convert time.Time to human readable format in the time zone with name tz
+// falls back to UTC if the time zone is unknown
+func humanDateIn(t time.Time, tz string) string {
+	if t.IsZero() {
+		return ""
+	}
+	loc, err := time.LoadLocation(tz)
+	if err!=nil {
+		return humanDate(t)
+	}
+	return t.In(loc).Format("02 Jan 2006 at 15:04 MST")
+}
+
+// markdown renderer for user content
+// raw html is escaped and unsafe links are dropped as goldmark is not used in unsafe mode
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// convert markdown to html which is safe to include in a template
+func markdown(source string) template.HTML {
+	var buf bytes.Buffer
+	err := markdownRenderer.Convert([]byte(source), &buf)
+	if err!=nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(buf.String())
+}
+
+// code is highlighted with css classes, the styles are in ui/static/css/highlight.css
+// as the content security policy does not allow inline styles
+// the css is the github style written out with chroma's WriteCSS
+// lines are formatted one at a time so the pre wrapper is left out
+var highlighter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(true))
+var highlightStyle = styles.Get("github")
+
+// a language offered on the create snippet form
+type language struct {
+	// name chroma knows the language by, "" for plain text
+	Name string
+	Label string
+}
+
+// languages offered on the create snippet form
+// knownLanguage accepts any other name chroma knows as well
+func languages() []language {
+	return []language{
+		{"", "Plain Text"},
+		{"bash", "Bash"},
+		{"c", "C"},
+		{"css", "CSS"},
+		{"go", "Go"},
+		{"html", "HTML"},
+		{"java", "Java"},
+		{"javascript", "JavaScript"},
+		{"json", "JSON"},
+		{"python", "Python"},
+		{"ruby", "Ruby"},
+		{"rust", "Rust"},
+		{"sql", "SQL"},
+		{"typescript", "TypeScript"},
+		{"yaml", "YAML"},
+	}
+}
+
+// report if language is a name or alias of a language which can be highlighted
+// "" is plain text
+func knownLanguage(language string) bool {
+	return language=="" || lexers.Get(language)!=nil
+}
+
+// report if a snippet can be shown in format and language
+// language has to fit in the language column of snippets and drafts
+func validContentFormat(format, language string) bool {
+	return validator.PermittedValue(format, models.FormatCode, models.FormatMarkdown) &&
+		validator.MaxLen(language, 30) && knownLanguage(language)
+}
+
+// highlight each line of code as html which is safe to include in a template
+// the code is tokenised as a whole so that tokens spanning lines such as block comments
+// keep their colour, unknown languages are shown as plain text
+func highlightLines(lines []snippetLine, language string) error {
+	lexer := lexers.Get(language)
+	if lexer==nil {
+		lexer = lexers.Fallback
+	}
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, strings.Join(texts, "\n"))
+	if err!=nil {
+		return err
+	}
+	for i, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		// some lexers add a newline at the end of the code
+		if i >= len(lines) {
+			break
+		}
+		// the newline ending a line is part of its last token
+		if n := len(tokens); n > 0 {
+			tokens[n-1].Value = strings.TrimSuffix(tokens[n-1].Value, "\n")
+		}
+		var buf bytes.Buffer
+		err = highlighter.Format(&buf, highlightStyle, chroma.Literator(tokens...))
+		if err!=nil {
+			return err
+		}
+		lines[i].HTML = template.HTML(buf.String())
+	}
+	return nil
+}
+
+// render snippet content as it is shown on the view page and in the preview
+// code is split into highlighted lines, markdown is rendered as sanitized html
+// and its plain lines are kept to show the source beneath
+// lines from start to end (inclusive) are marked as highlighted
+func renderContent(content, format, language string, start, end int) ([]snippetLine, template.HTML, error) {
+	lines := splitLines(content, start, end)
+	if format==models.FormatMarkdown {
+		return lines, markdown(content), nil
+	}
+	err := highlightLines(lines, language)
+	if err!=nil {
+		return nil, "", err
+	}
+	return lines, "", nil
+}
+
 // initialize template.FuncMap object and store in global variable
 // lookup table for template function and our created functions
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"humanDateIn": humanDateIn,
+	"markdown": markdown,
+	"languages": languages,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -75,5 +266,11 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		// add template set to cache with filename as key
 		cache[name] = ts
 	}
+	// parse the partials on their own so that fragments can be rendered without base
+	ts, err := template.New("partials").Funcs(functions).ParseFS(ui.Files, "html/partials/*.tmpl.html")
+	if err!=nil {
+		return nil, err
+	}
+	cache["partials"] = ts
 	return cache, nil
 }
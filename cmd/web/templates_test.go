@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -36,3 +37,107 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct{
+		name string
+		source string
+		want string
+	} {
+		{
+			name: "Emphasis",
+			source: "some *text*",
+			want: "<p>some <em>text</em></p>\n",
+		},
+		{
+			name: "Raw HTML",
+			source: "<script>alert(1)</script>",
+			want: "<!-- raw HTML omitted -->\n",
+		},
+		{
+			name: "Unsafe Link",
+			source: "[link](javascript:alert(1))",
+			want: "<p><a href=\"\">link</a></p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markdown(tt.source)
+			assert.Equal(t, string(got), tt.want)
+		})
+	}
+}
+
+func TestHumanDateIn(t *testing.T) {
+	tm := time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC)
+	assert.Equal(t, humanDateIn(tm, "Asia/Kolkata"), "17 Mar 2022 at 15:45 IST")
+	assert.Equal(t, humanDateIn(tm, "Not/AZone"), "17 Mar 2022 at 10:15")
+	assert.Equal(t, humanDateIn(time.Time{}, "UTC"), "")
+}
+
+func TestRenderContent(t *testing.T) {
+	tests := []struct{
+		name string
+		content string
+		format string
+		language string
+		lines int
+		contains string
+		excludes string
+	} {
+		{
+			name: "Markdown",
+			content: "some *text*\n\n<script>alert(1)</script>",
+			format: "markdown",
+			lines: 3,
+			contains: "<p>some <em>text</em></p>",
+			excludes: "<script>",
+		},
+		{
+			name: "Highlighted Code",
+			content: "package main",
+			format: "code",
+			language: "go",
+			lines: 1,
+			contains: `<span class="kn">package</span>`,
+		},
+		{
+			name: "Plain Code",
+			content: "<script>alert(1)</script>",
+			format: "code",
+			lines: 1,
+			contains: "&lt;script&gt;",
+			excludes: "<script>",
+		},
+		{
+			name: "Comment Spanning Lines",
+			content: "/* one\r\ntwo */\r\nx := 1",
+			format: "code",
+			language: "go",
+			lines: 3,
+			contains: `<span class="cm">two */</span>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, html, err := renderContent(tt.content, tt.format, tt.language, 0, 0)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, len(lines), tt.lines)
+			got := string(html)
+			for _, line := range lines {
+				got += string(line.HTML)
+			}
+			assert.Equal(t, strings.Contains(got, tt.contains), true)
+			if tt.excludes!="" {
+				assert.Equal(t, strings.Contains(got, tt.excludes), false)
+			}
+		})
+	}
+}
+
+func TestKnownLanguage(t *testing.T) {
+	assert.Equal(t, knownLanguage(""), true)
+	assert.Equal(t, knownLanguage("go"), true)
+	assert.Equal(t, knownLanguage("python"), true)
+	assert.Equal(t, knownLanguage("not-a-language"), false)
+}
@@ -0,0 +1,145 @@
+package botcheck
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"snippetbox.anukuljoshi/internals/tokens"
+)
+
+// names of the hidden fields added to protected forms
+const (
+	// left empty by people, bots which fill in every field give themselves away
+	HoneypotField = "website"
+	ChallengeField = "challenge"
+	SolutionField = "solution"
+)
+
+// the most leading zero bits a challenge can ask for
+// each bit doubles the work, 24 already takes a few seconds in a browser
+const MaxDifficulty = 32
+
+var (
+	ErrHoneypot = errors.New("botcheck: honeypot field filled in")
+	ErrTooFast = errors.New("botcheck: form submitted too quickly")
+	ErrInvalidChallenge = errors.New("botcheck: missing or invalid challenge")
+	ErrExpiredChallenge = errors.New("botcheck: expired challenge")
+	ErrNoProofOfWork = errors.New("botcheck: missing or wrong proof of work")
+)
+
+const purpose = "botcheck"
+
+// Checker tells people and bots apart without third party services or server side state
+//
+// every protected form carries a signed challenge with the time the form was rendered
+// forms submitted sooner than MinDelay or later than MaxAge are rejected, as are forms
+// with the honeypot field filled in
+// if Difficulty is above 0 the browser also has to find a solution whose SHA-256 hash
+// together with the challenge starts with Difficulty zero bits
+//
+// a challenge can be solved once and submitted repeatedly until it expires,
+// it makes each bot submission cost time and work but is not a rate limit
+type Checker struct {
+	signer *tokens.Signer
+	MinDelay time.Duration
+	MaxAge time.Duration
+	Difficulty int
+}
+
+// Challenge is rendered into a protected form
+type Challenge struct {
+	Token string
+	Difficulty int
+}
+
+func New(signer *tokens.Signer, difficulty int) *Checker {
+	return &Checker{
+		signer: signer,
+		MinDelay: 3 * time.Second,
+		MaxAge: 2 * time.Hour,
+		Difficulty: difficulty,
+	}
+}
+
+// create a challenge for a form rendered now
+func (c *Checker) Challenge() (*Challenge, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err!=nil {
+		return nil, err
+	}
+	token, err := c.signer.Sign(purpose, map[string]string{
+		"n": base64.RawURLEncoding.EncodeToString(nonce),
+		"t": strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"d": strconv.Itoa(c.Difficulty),
+	}, c.MaxAge)
+	if err!=nil {
+		return nil, err
+	}
+	return &Challenge{Token: token, Difficulty: c.Difficulty}, nil
+}
+
+// check the hidden fields of a submitted form
+func (c *Checker) Verify(honeypot, token, solution string) error {
+	if honeypot!="" {
+		return ErrHoneypot
+	}
+	data, err := c.signer.Verify(purpose, token)
+	if err!=nil {
+		if errors.Is(err, tokens.ErrExpiredToken) {
+			return ErrExpiredChallenge
+		}
+		return ErrInvalidChallenge
+	}
+	issued, err := strconv.ParseInt(data["t"], 10, 64)
+	if err!=nil {
+		return ErrInvalidChallenge
+	}
+	if time.Since(time.UnixMilli(issued)) < c.MinDelay {
+		return ErrTooFast
+	}
+	// the difficulty may have been raised since the form was rendered
+	difficulty, err := strconv.Atoi(data["d"])
+	if err!=nil || difficulty < c.Difficulty {
+		return ErrInvalidChallenge
+	}
+	if difficulty > 0 && !Solves(token, solution, difficulty) {
+		return ErrNoProofOfWork
+	}
+	return nil
+}
+
+// report if the SHA-256 hash of "token:solution" starts with difficulty zero bits
+func Solves(token, solution string, difficulty int) bool {
+	if solution=="" || len(solution) > 20 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+	return leadingZeros(sum[:]) >= difficulty
+}
+
+// find a solution for a challenge, used by tests, browsers run the same search in js
+func Solve(token string, difficulty int) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		if Solves(token, solution, difficulty) {
+			return solution
+		}
+	}
+}
+
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x!=0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,119 @@
+package botcheck
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.anukuljoshi/internals/assert"
+	"snippetbox.anukuljoshi/internals/tokens"
+)
+
+func TestVerify(t *testing.T) {
+	signer := tokens.NewSigner([]byte("secret"))
+
+	t.Run("Valid", func(t *testing.T) {
+		c := New(signer, 8)
+		c.MinDelay = 0
+		challenge, err := c.Challenge()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, challenge.Difficulty, 8)
+		solution := Solve(challenge.Token, challenge.Difficulty)
+		assert.Equal(t, c.Verify("", challenge.Token, solution), nil)
+	})
+
+	t.Run("No Proof Of Work Needed", func(t *testing.T) {
+		c := New(signer, 0)
+		c.MinDelay = 0
+		challenge, err := c.Challenge()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.Verify("", challenge.Token, ""), nil)
+	})
+
+	t.Run("Raised Difficulty", func(t *testing.T) {
+		c := New(signer, 0)
+		c.MinDelay = 0
+		challenge, err := c.Challenge()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		c.Difficulty = 8
+		assert.Equal(t, errors.Is(c.Verify("", challenge.Token, "0"), ErrInvalidChallenge), true)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		c := New(signer, 0)
+		c.MinDelay = 0
+		c.MaxAge = -time.Minute
+		challenge, err := c.Challenge()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, errors.Is(c.Verify("", challenge.Token, ""), ErrExpiredChallenge), true)
+	})
+
+	c := New(signer, 16)
+	challenge, err := c.Challenge()
+	if err!=nil {
+		t.Fatal(err)
+	}
+	tests := []struct{
+		name string
+		honeypot string
+		token string
+		solution string
+		minDelay time.Duration
+		err error
+	} {
+		{
+			name: "Honeypot",
+			honeypot: "http://spam.example.com",
+			token: challenge.Token,
+			err: ErrHoneypot,
+		},
+		{
+			name: "Too Fast",
+			token: challenge.Token,
+			minDelay: time.Minute,
+			err: ErrTooFast,
+		},
+		{
+			name: "Missing Challenge",
+			token: "",
+			err: ErrInvalidChallenge,
+		},
+		{
+			name: "Tampered Challenge",
+			token: "x" + challenge.Token,
+			err: ErrInvalidChallenge,
+		},
+		{
+			name: "Missing Solution",
+			token: challenge.Token,
+			err: ErrNoProofOfWork,
+		},
+		{
+			name: "Wrong Solution",
+			token: challenge.Token,
+			solution: "not-a-solution",
+			err: ErrNoProofOfWork,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.MinDelay = tt.minDelay
+			err := c.Verify(tt.honeypot, tt.token, tt.solution)
+			assert.Equal(t, errors.Is(err, tt.err), true)
+		})
+	}
+}
+
+func TestLeadingZeros(t *testing.T) {
+	assert.Equal(t, leadingZeros([]byte{0xff}), 0)
+	assert.Equal(t, leadingZeros([]byte{0x00, 0x10}), 11)
+	assert.Equal(t, leadingZeros([]byte{0x00, 0x00}), 16)
+}
@@ -0,0 +1,217 @@
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("directory: invalid credentials")
+	ErrAmbiguousUser = errors.New("directory: more than one entry matches the user filter")
+)
+
+// Config describes an LDAP directory users can log in with
+type Config struct {
+	// ldap://host:389 or ldaps://host:636
+	URL string
+	// upgrade ldap:// connections with StartTLS
+	StartTLS bool
+	TLSConfig *tls.Config
+	// account used to search for users and groups, anonymous if empty
+	BindDN string
+	BindPassword string
+	// where users are searched
+	BaseDN string
+	// filter which finds a user by the login, %s is replaced by the escaped login
+	// defaults to (&(objectClass=person)(mail=%s))
+	UserFilter string
+	// where groups are searched, defaults to BaseDN
+	GroupBaseDN string
+	// filter which finds the groups of a user, %s is replaced by the escaped DN of the user
+	// e.g. (&(objectClass=groupOfNames)(member=%s))
+	// the memberOf attribute of the user is used if empty
+	GroupFilter string
+	// attributes of user entries, default to mail, cn and uid
+	EmailAttribute string
+	NameAttribute string
+	UsernameAttribute string
+	// for connecting and for each request, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// Entry is what the directory tells us about a user
+type Entry struct {
+	DN string
+	Name string
+	Username string
+	Email string
+	// DNs of the groups the user is a member of
+	Groups []string
+}
+
+// the part of *ldap.Conn which is used, replaced in tests
+type conn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Directory authenticates users against an LDAP directory
+// it opens a new connection for each login
+type Directory struct {
+	Config Config
+
+	dial func() (conn, error)
+}
+
+func New(config Config) *Directory {
+	if config.UserFilter=="" {
+		config.UserFilter = "(&(objectClass=person)(mail=%s))"
+	}
+	if config.GroupBaseDN=="" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	if config.EmailAttribute=="" {
+		config.EmailAttribute = "mail"
+	}
+	if config.NameAttribute=="" {
+		config.NameAttribute = "cn"
+	}
+	if config.UsernameAttribute=="" {
+		config.UsernameAttribute = "uid"
+	}
+	if config.Timeout==0 {
+		config.Timeout = 10*time.Second
+	}
+	d := &Directory{Config: config}
+	d.dial = d.dialLDAP
+	return d
+}
+
+func (d *Directory) dialLDAP() (conn, error) {
+	c, err := ldap.DialURL(
+		d.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: d.Config.Timeout}),
+		ldap.DialWithTLSConfig(d.Config.TLSConfig),
+	)
+	if err!=nil {
+		return nil, err
+	}
+	c.SetTimeout(d.Config.Timeout)
+	if d.Config.StartTLS {
+		err = c.StartTLS(d.Config.TLSConfig)
+		if err!=nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// bind as the search account, or stay anonymous if there is none
+func (d *Directory) bindSearch(c conn) error {
+	if d.Config.BindDN=="" {
+		return nil
+	}
+	return c.Bind(d.Config.BindDN, d.Config.BindPassword)
+}
+
+// Authenticate finds the user with the login and checks the password by binding as the user
+// returns ErrInvalidCredentials if there is no such user or the password is wrong
+func (d *Directory) Authenticate(login, password string) (*Entry, error) {
+	// binding with an empty password is an anonymous bind, which most servers accept
+	if login=="" || password=="" {
+		return nil, ErrInvalidCredentials
+	}
+	c, err := d.dial()
+	if err!=nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	err = d.bindSearch(c)
+	if err!=nil {
+		return nil, err
+	}
+	result, err := c.Search(ldap.NewSearchRequest(
+		d.Config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(d.Config.Timeout/time.Second),
+		false,
+		fmt.Sprintf(d.Config.UserFilter, ldap.EscapeFilter(login)),
+		[]string{d.Config.EmailAttribute, d.Config.NameAttribute, d.Config.UsernameAttribute, "memberOf"},
+		nil,
+	))
+	if err!=nil {
+		// the size limit of 2 is hit when more than one entry matches
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrAmbiguousUser
+		}
+		return nil, err
+	}
+	if len(result.Entries)==0 {
+		return nil, ErrInvalidCredentials
+	}
+	if len(result.Entries) > 1 {
+		return nil, ErrAmbiguousUser
+	}
+	user := result.Entries[0]
+
+	err = c.Bind(user.DN, password)
+	if err!=nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	entry := &Entry{
+		DN: user.DN,
+		Name: user.GetAttributeValue(d.Config.NameAttribute),
+		Username: user.GetAttributeValue(d.Config.UsernameAttribute),
+		Email: user.GetAttributeValue(d.Config.EmailAttribute),
+		Groups: user.GetAttributeValues("memberOf"),
+	}
+	if d.Config.GroupFilter!="" {
+		entry.Groups, err = d.groups(c, user.DN)
+		if err!=nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// search the groups which have the user as a member
+// users may not be allowed to search, so bind as the search account again
+func (d *Directory) groups(c conn, dn string) ([]string, error) {
+	err := d.bindSearch(c)
+	if err!=nil {
+		return nil, err
+	}
+	result, err := c.Search(ldap.NewSearchRequest(
+		d.Config.GroupBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		int(d.Config.Timeout/time.Second),
+		false,
+		fmt.Sprintf(d.Config.GroupFilter, ldap.EscapeFilter(dn)),
+		[]string{"dn"},
+		nil,
+	))
+	if err!=nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
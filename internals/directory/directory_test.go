@@ -0,0 +1,175 @@
+package directory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+// a stand-in directory with users and groups kept in memory
+// filters are matched by checking which entry value they contain
+type testConn struct {
+	users []*ldap.Entry
+	passwords map[string]string
+	groups map[string][]string
+	// DN of the last successful bind
+	bound string
+	// filter of the last user search
+	filter string
+	closed bool
+}
+
+func (c *testConn) Bind(username, password string) error {
+	if c.passwords[username]!=password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *testConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.bound!="cn=search,dc=example,dc=com" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("not allowed"))
+	}
+	result := &ldap.SearchResult{}
+	if request.BaseDN=="ou=groups,dc=example,dc=com" {
+		for group, members := range c.groups {
+			for _, member := range members {
+				if strings.Contains(request.Filter, "(member="+member+")") {
+					result.Entries = append(result.Entries, &ldap.Entry{DN: group})
+				}
+			}
+		}
+		return result, nil
+	}
+	c.filter = request.Filter
+	for _, user := range c.users {
+		if strings.Contains(request.Filter, "(mail="+user.GetAttributeValue("mail")+")") {
+			result.Entries = append(result.Entries, user)
+		}
+	}
+	if len(result.Entries) > request.SizeLimit {
+		return result, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+	}
+	return result, nil
+}
+
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestDirectory(config Config) (*Directory, *testConn) {
+	c := &testConn{
+		users: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"mail": {"alice@example.com"},
+				"cn": {"Alice Cooper"},
+				"uid": {"alice"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"mail": {"shared@example.com"},
+			}),
+			ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+				"mail": {"shared@example.com"},
+			}),
+		},
+		passwords: map[string]string{
+			"cn=search,dc=example,dc=com": "search-secret",
+			"uid=alice,ou=people,dc=example,dc=com": "alice-secret",
+		},
+		groups: map[string][]string{
+			"cn=admins,ou=groups,dc=example,dc=com": {"uid=alice,ou=people,dc=example,dc=com"},
+		},
+	}
+	config.BindDN = "cn=search,dc=example,dc=com"
+	config.BindPassword = "search-secret"
+	config.BaseDN = "dc=example,dc=com"
+	d := New(config)
+	d.dial = func() (conn, error) {
+		return c, nil
+	}
+	return d, c
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		d, c := newTestDirectory(Config{})
+		entry, err := d.Authenticate("alice@example.com", "alice-secret")
+		if err!=nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, entry.DN, "uid=alice,ou=people,dc=example,dc=com")
+		assert.Equal(t, entry.Name, "Alice Cooper")
+		assert.Equal(t, entry.Username, "alice")
+		assert.Equal(t, entry.Email, "alice@example.com")
+		assert.Equal(t, len(entry.Groups), 1)
+		assert.Equal(t, entry.Groups[0], "cn=staff,ou=groups,dc=example,dc=com")
+		assert.Equal(t, c.closed, true)
+	})
+
+	t.Run("Group Filter", func(t *testing.T) {
+		d, c := newTestDirectory(Config{
+			GroupBaseDN: "ou=groups,dc=example,dc=com",
+			GroupFilter: "(&(objectClass=groupOfNames)(member=%s))",
+		})
+		entry, err := d.Authenticate("alice@example.com", "alice-secret")
+		if err!=nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(entry.Groups), 1)
+		assert.Equal(t, entry.Groups[0], "cn=admins,ou=groups,dc=example,dc=com")
+		// groups are searched as the search account
+		assert.Equal(t, c.bound, "cn=search,dc=example,dc=com")
+	})
+
+	t.Run("Escaped Login", func(t *testing.T) {
+		d, c := newTestDirectory(Config{})
+		_, err := d.Authenticate("*)(uid=*", "alice-secret")
+		assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+		assert.Equal(t, c.filter, `(&(objectClass=person)(mail=\2a\29\28uid=\2a))`)
+	})
+
+	tests := []struct{
+		name string
+		login string
+		password string
+		err error
+	} {
+		{
+			name: "Wrong Password",
+			login: "alice@example.com",
+			password: "wrong",
+			err: ErrInvalidCredentials,
+		},
+		{
+			name: "Empty Password",
+			login: "alice@example.com",
+			password: "",
+			err: ErrInvalidCredentials,
+		},
+		{
+			name: "Unknown User",
+			login: "nobody@example.com",
+			password: "alice-secret",
+			err: ErrInvalidCredentials,
+		},
+		{
+			name: "Ambiguous User",
+			login: "shared@example.com",
+			password: "secret",
+			err: ErrAmbiguousUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDirectory(Config{})
+			_, err := d.Authenticate(tt.login, tt.password)
+			assert.Equal(t, errors.Is(err, tt.err), true)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io/fs"
+	"text/template"
+)
+
+// Message is a single email with a plain text and an html body
+type Message struct {
+	To string
+	Subject string
+	PlainBody string
+	HTMLBody string
+}
+
+// Mailer sends email messages
+// implemented by SMTPMailer for production, WriterMailer for development
+// and MemoryMailer for tests
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// create a message to be sent to recipient from an email template in fsys
+// the template must define "subject", "plainBody" and "htmlBody"
+func NewMessage(fsys fs.FS, file, recipient string, data any) (Message, error) {
+	msg := Message{To: recipient}
+
+	// subject and plain body are not html so they are rendered with text/template
+	tmpl, err := template.New("email").ParseFS(fsys, file)
+	if err!=nil {
+		return msg, err
+	}
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err!=nil {
+		return msg, err
+	}
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err!=nil {
+		return msg, err
+	}
+
+	// html body is rendered with html/template so that data is escaped
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(fsys, file)
+	if err!=nil {
+		return msg, err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err!=nil {
+		return msg, err
+	}
+
+	msg.Subject = subject.String()
+	msg.PlainBody = plainBody.String()
+	msg.HTMLBody = htmlBody.String()
+	return msg, nil
+}
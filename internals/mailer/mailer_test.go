@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+var testFS = fstest.MapFS{
+	"welcome.tmpl.html": &fstest.MapFile{Data: []byte(`
+{{define "subject"}}Welcome {{.Name}}{{end}}
+{{define "plainBody"}}Hi {{.Name}}, follow {{.Link}}{{end}}
+{{define "htmlBody"}}<p>Hi {{.Name}}, follow <a href="{{.Link}}">this link</a></p>{{end}}
+`)},
+}
+
+func TestNewMessage(t *testing.T) {
+	data := map[string]string{
+		"Name": "<Alice>",
+		"Link": "https://example.com/verify?token=a&b",
+	}
+	msg, err := NewMessage(testFS, "welcome.tmpl.html", "alice@example.com", data)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.To, "alice@example.com")
+	assert.Equal(t, msg.Subject, "Welcome <Alice>")
+	assert.Equal(t, msg.PlainBody, "Hi <Alice>, follow https://example.com/verify?token=a&b")
+	assert.Equal(t, msg.HTMLBody, `<p>Hi &lt;Alice&gt;, follow <a href="https://example.com/verify?token=a&amp;b">this link</a></p>`)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	m.Send(Message{To: "a@example.com"})
+	m.Send(Message{To: "b@example.com"})
+	messages := m.Messages()
+	assert.Equal(t, len(messages), 2)
+	assert.Equal(t, messages[1].To, "b@example.com")
+}
+
+func TestWriterMailer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	m := NewWriterMailer(buf)
+	err := m.Send(Message{To: "a@example.com", Subject: "Hello", PlainBody: "Body"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(buf.String(), "Subject: Hello\n\nBody"), true)
+}
+
+func TestBuildMessage(t *testing.T) {
+	body, err := buildMessage("noreply@example.com", Message{
+		To: "a@example.com",
+		Subject: "Hello",
+		PlainBody: "plain",
+		HTMLBody: "<p>html</p>",
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(string(body), "Content-Type: multipart/alternative"), true)
+	assert.Equal(t, strings.Contains(string(body), "<p>html</p>"), true)
+}
@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer sends messages through an SMTP server
+// works with any SMTP compatible server, including local ones such as mailpit
+// sender is the from address and may include a display name, e.g. "SnippetBox <no-reply@example.com>"
+type SMTPMailer struct {
+	Host string
+	Port int
+	Username string
+	Password string
+	Sender string
+}
+
+// send msg through the SMTP server
+// authentication is only attempted if a username is configured
+func (m *SMTPMailer) Send(msg Message) error {
+	body, err := buildMessage(m.Sender, msg)
+	if err!=nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username!="" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	// the envelope sender must be a bare address, the sender may include a display name
+	from, err := mail.ParseAddress(m.Sender)
+	if err!=nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, from.Address, []string{msg.To}, body)
+}
+
+// build a multipart/alternative MIME message from msg
+func buildMessage(sender string, msg Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", sender)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct{
+		contentType string
+		body string
+	} {
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := writer.CreatePart(header)
+		if err!=nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(part.body))
+		if err!=nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err!=nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	if err!=nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// WriterMailer writes messages to an io.Writer instead of sending them
+// used in development with os.Stdout or a file
+type WriterMailer struct {
+	mu sync.Mutex
+	w io.Writer
+}
+
+func NewWriterMailer(w io.Writer) *WriterMailer {
+	return &WriterMailer{w: w}
+}
+
+// write the plain text version of msg
+func (m *WriterMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "To: %s\nSubject: %s\n\n%s\n----\n", msg.To, msg.Subject, msg.PlainBody)
+	return err
+}
+
+// MemoryMailer keeps sent messages in memory so that tests can inspect them
+type MemoryMailer struct {
+	mu sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// return a copy of all messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
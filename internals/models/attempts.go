@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// failed logins are counted per key, e.g. per account and per client IP
+// the counters live in the database so they are shared by all instances of the app
+//
+//	CREATE TABLE login_attempts (
+//		attempt_key VARCHAR(255) NOT NULL PRIMARY KEY,
+//		failures INTEGER NOT NULL,
+//		last_failure DATETIME NOT NULL,
+//		locked_until DATETIME NULL
+//	);
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// LoginPolicy decides when a key gets locked and for how long
+// after Threshold failures the key is locked for Backoff, which doubles with every further failure up to MaxBackoff
+// counters start again once there has been no failure for Window
+type LoginPolicy struct {
+	Threshold int
+	Backoff time.Duration
+	MaxBackoff time.Duration
+	Window time.Duration
+}
+
+// return how much longer key is locked, or 0 if it is not locked
+func (m *LoginAttemptModel) Locked(key string) (time.Duration, error) {
+	query := `
+		SELECT TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP(), locked_until)
+		FROM login_attempts
+		WHERE attempt_key = ? AND locked_until > UTC_TIMESTAMP()
+	`
+	var seconds int
+	err := m.DB.QueryRow(query, key).Scan(&seconds)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// round up so a key is never reported as unlocked while it is still locked
+	return time.Duration(seconds+1)*time.Second, nil
+}
+
+// record a failed login for key and return the number of failures in the current window
+// the counter is updated in a single statement so concurrent failures from several instances are all counted
+func (m *LoginAttemptModel) Fail(key string, policy LoginPolicy) (int, error) {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// assignments are applied from left to right so locked_until sees the new failure count
+	// and failures sees the previous last_failure
+	query := `
+		INSERT INTO login_attempts (attempt_key, failures, last_failure, locked_until)
+		VALUES (?, 1, UTC_TIMESTAMP(), IF(1 >= ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), NULL))
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND), 1, failures + 1),
+			locked_until = IF(
+				failures >= ?,
+				DATE_ADD(UTC_TIMESTAMP(), INTERVAL LEAST(? * POW(2, failures - ?), ?) SECOND),
+				NULL
+			),
+			last_failure = UTC_TIMESTAMP()
+	`
+	backoff := int(policy.Backoff.Seconds())
+	_, err = tx.Exec(query,
+		key, policy.Threshold, backoff,
+		int(policy.Window.Seconds()),
+		policy.Threshold, backoff, policy.Threshold, int(policy.MaxBackoff.Seconds()),
+	)
+	if err!=nil {
+		return 0, err
+	}
+	// the row stays locked until commit so this reads our own update
+	var failures int
+	err = tx.QueryRow(`SELECT failures FROM login_attempts WHERE attempt_key = ?`, key).Scan(&failures)
+	if err!=nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err!=nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// forget the failed logins of key
+func (m *LoginAttemptModel) Reset(key string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_attempts WHERE attempt_key = ?`, key)
+	return err
+}
@@ -0,0 +1,32 @@
+package models
+
+import "errors"
+
+// Authenticator checks the credentials a user entered on the login form
+// and returns the id of the matching user
+// returns ErrInvalidCredentials if the credentials are not accepted
+type Authenticator interface {
+	Authenticate(email, password string) (int, error)
+}
+
+// AuthenticatorChain tries each authenticator in order until one accepts the credentials
+// an authenticator which fails, e.g. because a directory is down, does not stop the
+// others from being tried, but its error is returned if none accepts the credentials
+type AuthenticatorChain []Authenticator
+
+func (c AuthenticatorChain) Authenticate(email, password string) (int, error) {
+	var firstErr error
+	for _, a := range c {
+		id, err := a.Authenticate(email, password)
+		if err==nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) && firstErr==nil {
+			firstErr = err
+		}
+	}
+	if firstErr!=nil {
+		return 0, firstErr
+	}
+	return 0, ErrInvalidCredentials
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// visibility of a collection
+// unlisted collections can be viewed by anyone with the share link but are not listed anywhere
+// the share link contains a random token, the sequential id is only accepted from the owner
+const (
+	VisibilityPrivate = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic = "public"
+)
+
+// collections are named, ordered groups of snippets owned by a user
+// a snippet can belong to any number of collections
+//
+//	CREATE TABLE collections (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		user_id INTEGER NOT NULL,
+//		name VARCHAR(100) NOT NULL,
+//		visibility VARCHAR(10) NOT NULL,
+//		position INTEGER NOT NULL,
+//		share_token CHAR(22) NOT NULL,
+//		created DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_collections_user_id ON collections(user_id);
+//	CREATE UNIQUE INDEX idx_collections_share_token ON collections(share_token);
+//
+//	CREATE TABLE collection_snippets (
+//		collection_id INTEGER NOT NULL,
+//		snippet_id INTEGER NOT NULL,
+//		position INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		PRIMARY KEY (collection_id, snippet_id)
+//	);
+type Collection struct {
+	ID int
+	UserID int
+	Name string
+	Visibility string
+	Position int
+	// random token in the share link of the collection
+	ShareToken string
+	Created time.Time
+	// number of snippets in the collection, including expired ones
+	Snippets int
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// insert a new collection for user, placed after the user's other collections
+// the user row is locked so that collections created at the same time get different positions
+func (m *CollectionModel) Insert(userID int, name, visibility, shareToken string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = lockRow(tx, `SELECT id FROM users WHERE id = ? FOR UPDATE`, userID)
+	if err!=nil {
+		return 0, err
+	}
+	query := `
+		INSERT INTO collections (user_id, name, visibility, position, share_token, created)
+		SELECT ?, ?, ?, COALESCE(MAX(position), 0) + 1, ?, UTC_TIMESTAMP()
+		FROM collections
+		WHERE user_id = ?
+	`
+	result, err := tx.Exec(query, userID, name, visibility, shareToken, userID)
+	if err!=nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err!=nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err!=nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// run a SELECT ... FOR UPDATE query for a single row in tx
+// returns ErrNoRecord if the row does not exist
+func lockRow(tx *sql.Tx, query string, id int) error {
+	var locked int
+	err := tx.QueryRow(query, id).Scan(&locked)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	return nil
+}
+
+// return a specific collection based on id
+func (m *CollectionModel) Get(id int) (*Collection, error) {
+	return m.get(`WHERE id = ?`, id)
+}
+
+// return the collection with the token of a share link
+func (m *CollectionModel) GetByShareToken(token string) (*Collection, error) {
+	return m.get(`WHERE share_token = ?`, token)
+}
+
+// select the single collection matching where
+func (m *CollectionModel) get(where string, arg any) (*Collection, error) {
+	query := `
+		SELECT id, user_id, name, visibility, position, share_token, created,
+			(SELECT COUNT(*) FROM collection_snippets WHERE collection_id = collections.id)
+		FROM collections
+	` + where
+	c := &Collection{}
+	err := m.DB.QueryRow(query, arg).Scan(
+		&c.ID,
+		&c.UserID,
+		&c.Name,
+		&c.Visibility,
+		&c.Position,
+		&c.ShareToken,
+		&c.Created,
+		&c.Snippets,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// return all collections of a user in their order
+func (m *CollectionModel) ForUser(userID int) ([]*Collection, error) {
+	query := `
+		SELECT id, user_id, name, visibility, position, share_token, created,
+			(SELECT COUNT(*) FROM collection_snippets WHERE collection_id = collections.id)
+		FROM collections
+		WHERE user_id = ?
+		ORDER BY position
+	`
+	return m.list(query, userID)
+}
+
+// return the public collections of a user in their order
+func (m *CollectionModel) PublicForUser(userID int) ([]*Collection, error) {
+	query := `
+		SELECT id, user_id, name, visibility, position, share_token, created,
+			(SELECT COUNT(*) FROM collection_snippets WHERE collection_id = collections.id)
+		FROM collections
+		WHERE user_id = ? AND visibility = ?
+		ORDER BY position
+	`
+	return m.list(query, userID, VisibilityPublic)
+}
+
+// run a query which selects all columns of collections and the number of snippets
+// and map the rows to collections
+func (m *CollectionModel) list(query string, args ...any) ([]*Collection, error) {
+	rows, err := m.DB.Query(query, args...)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collections := []*Collection{}
+	for rows.Next() {
+		c := &Collection{}
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.Visibility,
+			&c.Position,
+			&c.ShareToken,
+			&c.Created,
+			&c.Snippets,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// rename a collection and change its visibility
+func (m *CollectionModel) Update(id int, name, visibility string) error {
+	query := `
+		UPDATE collections
+		SET name = ?, visibility = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(query, name, visibility, id)
+	return err
+}
+
+// delete a collection, the snippets in it are not deleted
+func (m *CollectionModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`DELETE FROM collection_snippets WHERE collection_id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collections WHERE id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// move a collection one place up or down in the order of the user's collections
+func (m *CollectionModel) Move(userID, id int, up bool) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	var position int
+	query := `
+		SELECT position
+		FROM collections
+		WHERE id = ? AND user_id = ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, id, userID).Scan(&position)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	// find the collection to swap places with
+	query = `
+		SELECT id, position
+		FROM collections
+		WHERE user_id = ? AND position > ?
+		ORDER BY position
+		LIMIT 1
+		FOR UPDATE
+	`
+	if up {
+		query = `
+			SELECT id, position
+			FROM collections
+			WHERE user_id = ? AND position < ?
+			ORDER BY position DESC
+			LIMIT 1
+			FOR UPDATE
+		`
+	}
+	var otherID, otherPosition int
+	err = tx.QueryRow(query, userID, position).Scan(&otherID, &otherPosition)
+	if err!=nil {
+		// already the first or last collection
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	_, err = tx.Exec(`UPDATE collections SET position = ? WHERE id = ?`, otherPosition, id)
+	if err!=nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE collections SET position = ? WHERE id = ?`, position, otherID)
+	if err!=nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// add a snippet to the end of a collection, adding it again has no effect
+// the collection row is locked so that snippets added at the same time get different positions
+func (m *CollectionModel) AddSnippet(id, snippetID int) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = lockRow(tx, `SELECT id FROM collections WHERE id = ? FOR UPDATE`, id)
+	if err!=nil {
+		return err
+	}
+	query := `
+		INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, position, created)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1, UTC_TIMESTAMP()
+		FROM collection_snippets
+		WHERE collection_id = ?
+	`
+	_, err = tx.Exec(query, id, snippetID, id)
+	if err!=nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// remove a snippet from a collection
+func (m *CollectionModel) RemoveSnippet(id, snippetID int) error {
+	query := `
+		DELETE FROM collection_snippets
+		WHERE collection_id = ? AND snippet_id = ?
+	`
+	_, err := m.DB.Exec(query, id, snippetID)
+	return err
+}
+
+// move a snippet one place up or down within a collection
+func (m *CollectionModel) MoveSnippet(id, snippetID int, up bool) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	var position int
+	query := `
+		SELECT position
+		FROM collection_snippets
+		WHERE collection_id = ? AND snippet_id = ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, id, snippetID).Scan(&position)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	// find the snippet to swap places with
+	query = `
+		SELECT snippet_id, position
+		FROM collection_snippets
+		WHERE collection_id = ? AND position > ?
+		ORDER BY position
+		LIMIT 1
+		FOR UPDATE
+	`
+	if up {
+		query = `
+			SELECT snippet_id, position
+			FROM collection_snippets
+			WHERE collection_id = ? AND position < ?
+			ORDER BY position DESC
+			LIMIT 1
+			FOR UPDATE
+		`
+	}
+	var otherID, otherPosition int
+	err = tx.QueryRow(query, id, position).Scan(&otherID, &otherPosition)
+	if err!=nil {
+		// already the first or last snippet
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	query = `
+		UPDATE collection_snippets
+		SET position = ?
+		WHERE collection_id = ? AND snippet_id = ?
+	`
+	_, err = tx.Exec(query, otherPosition, id, snippetID)
+	if err!=nil {
+		return err
+	}
+	_, err = tx.Exec(query, position, id, otherID)
+	if err!=nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// comments are discussions on a snippet, a comment with line 0 is a general comment
+// otherwise it is attached to that line of the revision of the snippet it was made on
+// the revision is the hash of the content, see Snippet.Revision
+//
+//	CREATE TABLE comments (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		snippet_id INTEGER NOT NULL,
+//		user_id INTEGER NOT NULL,
+//		line INTEGER NOT NULL DEFAULT 0,
+//		revision CHAR(64) NOT NULL DEFAULT '',
+//		content TEXT NOT NULL,
+//		created DATETIME NOT NULL,
+//		updated DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
+type Comment struct {
+	ID int
+	SnippetID int
+	UserID int
+	UserName string
+	Line int
+	Revision string
+	Content string
+	Created time.Time
+	Updated time.Time
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// insert a new comment on a snippet, on line of revision or a general comment if line is 0
+func (m *CommentModel) Insert(snippetID, userID, line int, revision, content string) (int, error) {
+	query := `
+		INSERT INTO comments (snippet_id, user_id, line, revision, content, created, updated)
+		VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())
+	`
+	result, err := m.DB.Exec(query, snippetID, userID, line, revision, content)
+	if err!=nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err!=nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// return a specific comment based on id
+func (m *CommentModel) Get(id int) (*Comment, error) {
+	query := `
+		SELECT c.id, c.snippet_id, c.user_id, u.name, c.line, c.revision, c.content, c.created, c.updated
+		FROM comments c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c.id = ?
+	`
+	c := &Comment{}
+	err := m.DB.QueryRow(query, id).Scan(
+		&c.ID,
+		&c.SnippetID,
+		&c.UserID,
+		&c.UserName,
+		&c.Line,
+		&c.Revision,
+		&c.Content,
+		&c.Created,
+		&c.Updated,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// return all comments on a snippet, oldest first
+func (m *CommentModel) ForSnippet(snippetID int) ([]*Comment, error) {
+	query := `
+		SELECT c.id, c.snippet_id, c.user_id, u.name, c.line, c.revision, c.content, c.created, c.updated
+		FROM comments c
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c.snippet_id = ?
+		ORDER BY c.id
+	`
+	rows, err := m.DB.Query(query, snippetID)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	for rows.Next() {
+		c := &Comment{}
+		err := rows.Scan(
+			&c.ID,
+			&c.SnippetID,
+			&c.UserID,
+			&c.UserName,
+			&c.Line,
+			&c.Revision,
+			&c.Content,
+			&c.Created,
+			&c.Updated,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// update the content of a comment
+func (m *CommentModel) Update(id int, content string) error {
+	query := `
+		UPDATE comments
+		SET content = ?, updated = UTC_TIMESTAMP()
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(query, content, id)
+	return err
+}
+
+// delete a comment
+func (m *CommentModel) Delete(id int) error {
+	query := `
+		DELETE FROM comments
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(query, id)
+	return err
+}
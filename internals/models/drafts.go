@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// drafts are autosaved copies of the create snippet form
+//
+//	CREATE TABLE drafts (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		user_id INTEGER NOT NULL,
+//		title VARCHAR(100) NOT NULL,
+//		content TEXT NOT NULL,
+//		format VARCHAR(10) NOT NULL DEFAULT 'code',
+//		language VARCHAR(30) NOT NULL DEFAULT '',
+//		expires INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		updated DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_drafts_user_id ON drafts(user_id);
+type Draft struct {
+	ID int
+	UserID int
+	Title string
+	Content string
+	Format string
+	Language string
+	Expires int
+	Created time.Time
+	Updated time.Time
+}
+
+type DraftModel struct {
+	DB *sql.DB
+}
+
+// drafts which have not been saved for this many days are deleted
+const DraftLifetimeDays = 30
+
+// users keep at most this many drafts
+// creating a new draft deletes the ones saved least recently
+const MaxDraftsPerUser = 20
+
+// save a draft for user, creates a new draft if id is 0 or the draft no longer exists
+// returns the id of the saved draft
+func (m *DraftModel) Save(id, userID int, title, content, format, language string, expires int) (int, error) {
+	if id!=0 {
+		_, err := m.Get(id, userID)
+		if err==nil {
+			query := `
+				UPDATE drafts
+				SET title = ?, content = ?, format = ?, language = ?, expires = ?, updated = UTC_TIMESTAMP()
+				WHERE id = ? AND user_id = ?
+			`
+			_, err = m.DB.Exec(query, title, content, format, language, expires, id, userID)
+			if err!=nil {
+				return 0, err
+			}
+			return id, nil
+		}
+		if !errors.Is(err, ErrNoRecord) {
+			return 0, err
+		}
+	}
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	query := `
+		INSERT INTO drafts (user_id, title, content, format, language, expires, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())
+	`
+	result, err := tx.Exec(query, userID, title, content, format, language, expires)
+	if err!=nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	if err!=nil {
+		return 0, err
+	}
+	query = `
+		DELETE FROM drafts
+		WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM (
+				SELECT id FROM drafts
+				WHERE user_id = ?
+				ORDER BY updated DESC, id DESC
+				LIMIT ?
+			) AS newest
+		)
+	`
+	_, err = tx.Exec(query, userID, userID, MaxDraftsPerUser)
+	if err!=nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err!=nil {
+		return 0, err
+	}
+	return int(newID), nil
+}
+
+// return a draft belonging to user
+func (m *DraftModel) Get(id, userID int) (*Draft, error) {
+	query := `
+		SELECT id, user_id, title, content, format, language, expires, created, updated
+		FROM drafts
+		WHERE
+			id = ? AND user_id = ? AND
+			updated > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY)
+	`
+	d := &Draft{}
+	err := m.DB.QueryRow(query, id, userID, DraftLifetimeDays).Scan(
+		&d.ID,
+		&d.UserID,
+		&d.Title,
+		&d.Content,
+		&d.Format,
+		&d.Language,
+		&d.Expires,
+		&d.Created,
+		&d.Updated,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// return all drafts of user, most recently saved first
+func (m *DraftModel) ForUser(userID int) ([]*Draft, error) {
+	query := `
+		SELECT id, user_id, title, content, format, language, expires, created, updated
+		FROM drafts
+		WHERE
+			user_id = ? AND
+			updated > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY)
+		ORDER BY updated DESC
+	`
+	rows, err := m.DB.Query(query, userID, DraftLifetimeDays)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drafts := []*Draft{}
+	for rows.Next() {
+		d := &Draft{}
+		err := rows.Scan(
+			&d.ID,
+			&d.UserID,
+			&d.Title,
+			&d.Content,
+			&d.Format,
+			&d.Language,
+			&d.Expires,
+			&d.Created,
+			&d.Updated,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return drafts, nil
+}
+
+// delete a draft belonging to user
+func (m *DraftModel) Delete(id, userID int) error {
+	query := `
+		DELETE FROM drafts
+		WHERE id = ? AND user_id = ?
+	`
+	_, err := m.DB.Exec(query, id, userID)
+	return err
+}
+
+// delete all drafts which have not been saved in DraftLifetimeDays
+func (m *DraftModel) DeleteExpired() error {
+	query := `
+		DELETE FROM drafts
+		WHERE updated <= DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY)
+	`
+	_, err := m.DB.Exec(query, DraftLifetimeDays)
+	return err
+}
@@ -6,4 +6,6 @@ var (
 	ErrNoRecord =  errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	ErrDuplicateUsername = errors.New("models: duplicate username")
+	ErrDuplicateReport = errors.New("models: duplicate report")
 )
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// accounts at external identity providers which users log in with
+// subjects are only unique for each issuer
+//
+//	CREATE TABLE user_identities (
+//		issuer VARCHAR(255) NOT NULL,
+//		subject VARCHAR(255) NOT NULL,
+//		user_id INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		PRIMARY KEY (issuer, subject)
+//	);
+//	CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// return the user linked to the subject at issuer
+func (m *IdentityModel) GetUserID(issuer, subject string) (int, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+	var userID int
+	err := m.DB.QueryRow(query, issuer, subject).Scan(&userID)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// link the subject at issuer to a user
+func (m *IdentityModel) Link(issuer, subject string, userID int) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created)
+		VALUES (?, ?, ?, UTC_TIMESTAMP())
+	`
+	_, err := m.DB.Exec(query, issuer, subject, userID)
+	return err
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// actions moderators take, one decision can log several, e.g. delete and ban
+const (
+	ModerationHide = "hide"
+	ModerationDismiss = "dismiss"
+	ModerationDelete = "delete"
+	ModerationWarn = "warn"
+	ModerationBan = "ban"
+)
+
+// every moderation decision is logged
+// the snippet title is kept as the snippet may have been deleted
+//
+//	CREATE TABLE moderation_log (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		moderator_id INTEGER NOT NULL,
+//		snippet_id INTEGER NOT NULL,
+//		snippet_title VARCHAR(100) NOT NULL,
+//		author_id INTEGER NOT NULL,
+//		action VARCHAR(20) NOT NULL,
+//		note TEXT NOT NULL,
+//		created DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_moderation_log_created ON moderation_log(created);
+type ModerationEntry struct {
+	ID int
+	ModeratorID int
+	// name of the moderator
+	Moderator string
+	SnippetID int
+	SnippetTitle string
+	AuthorID int
+	// name of the author of the snippet
+	Author string
+	Action string
+	Note string
+	Created time.Time
+}
+
+type ModerationLogModel struct {
+	DB *sql.DB
+}
+
+// log a moderation action
+func (m *ModerationLogModel) Insert(moderatorID, snippetID int, snippetTitle string, authorID int, action, note string) error {
+	query := `
+		INSERT INTO moderation_log (moderator_id, snippet_id, snippet_title, author_id, action, note, created)
+		VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())
+	`
+	_, err := m.DB.Exec(query, moderatorID, snippetID, snippetTitle, authorID, action, note)
+	return err
+}
+
+// return the most recent moderation actions, newest first
+func (m *ModerationLogModel) Latest(limit int) ([]*ModerationEntry, error) {
+	query := `
+		SELECT l.id, l.moderator_id, COALESCE(mu.name, ''), l.snippet_id, l.snippet_title,
+			l.author_id, COALESCE(au.name, ''), l.action, l.note, l.created
+		FROM moderation_log l
+		LEFT JOIN users mu ON mu.id = l.moderator_id
+		LEFT JOIN users au ON au.id = l.author_id
+		ORDER BY l.id DESC
+		LIMIT ?
+	`
+	rows, err := m.DB.Query(query, limit)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*ModerationEntry{}
+	for rows.Next() {
+		e := &ModerationEntry{}
+		err := rows.Scan(
+			&e.ID,
+			&e.ModeratorID,
+			&e.Moderator,
+			&e.SnippetID,
+			&e.SnippetTitle,
+			&e.AuthorID,
+			&e.Author,
+			&e.Action,
+			&e.Note,
+			&e.Created,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// recovery codes let users log in when they lose the device with their authenticator app
+// only the SHA-256 hash of a code is stored and each code can be used once
+//
+//	CREATE TABLE recovery_codes (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		user_id INTEGER NOT NULL,
+//		code_hash CHAR(64) NOT NULL
+//	);
+//	CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// replace all recovery codes of a user with new ones
+func (m *RecoveryCodeModel) Replace(userID int, codeHashes []string) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err!=nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash)
+		if err!=nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// use up a recovery code of a user
+// returns false if the code does not exist or was already used
+func (m *RecoveryCodeModel) Use(userID int, codeHash string) (bool, error) {
+	result, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?`, userID, codeHash)
+	if err!=nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err!=nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// return the number of unused recovery codes of a user
+func (m *RecoveryCodeModel) Remaining(userID int) (int, error) {
+	var count int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+// delete all recovery codes of a user
+func (m *RecoveryCodeModel) DeleteForUser(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	return err
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// categories users can report a snippet for
+const (
+	ReportSpam = "spam"
+	ReportMalware = "malware"
+	ReportLeakedSecret = "leaked secret"
+	ReportOther = "other"
+)
+
+var ReportCategories = []string{ReportSpam, ReportMalware, ReportLeakedSecret, ReportOther}
+
+// a report is open until a moderator dismisses it or acts on the snippet
+const (
+	ReportOpen = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned = "actioned"
+)
+
+// reports are made by users about snippets which break the rules
+// each user can report a snippet once
+//
+//	CREATE TABLE reports (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		snippet_id INTEGER NOT NULL,
+//		user_id INTEGER NOT NULL,
+//		category VARCHAR(20) NOT NULL,
+//		details TEXT NOT NULL,
+//		status VARCHAR(10) NOT NULL DEFAULT 'open',
+//		created DATETIME NOT NULL,
+//		CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, user_id)
+//	);
+//	CREATE INDEX idx_reports_status ON reports(status);
+type Report struct {
+	ID int
+	SnippetID int
+	UserID int
+	// name of the user who made the report
+	UserName string
+	Category string
+	Details string
+	Status string
+	Created time.Time
+}
+
+// a snippet in the moderation queue with a summary of its open reports
+type ReportedSnippet struct {
+	SnippetID int
+	Title string
+	AuthorID int
+	Author string
+	Hidden bool
+	Reports int
+	// the distinct categories of the reports, comma separated
+	Categories string
+	FirstReported time.Time
+}
+
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// add a report about a snippet
+// returns ErrDuplicateReport if the user has already reported the snippet
+func (m *ReportModel) Insert(snippetID, userID int, category, details string) error {
+	query := `
+		INSERT INTO reports (snippet_id, user_id, category, details, status, created)
+		VALUES (?, ?, ?, ?, 'open', UTC_TIMESTAMP())
+	`
+	_, err := m.DB.Exec(query, snippetID, userID, category, details)
+	if err!=nil {
+		var mySqlError *mysql.MySQLError
+		if errors.As(err, &mySqlError) && mySqlError.Number==1062 {
+			return ErrDuplicateReport
+		}
+		return err
+	}
+	return nil
+}
+
+// return the snippets which have open reports, the longest waiting first
+func (m *ReportModel) Queue() ([]*ReportedSnippet, error) {
+	query := `
+		SELECT s.id, s.title, s.user_id, COALESCE(u.username, u.name, ''), s.hidden,
+			COUNT(*), GROUP_CONCAT(DISTINCT r.category ORDER BY r.category SEPARATOR ', '), MIN(r.created)
+		FROM reports r
+		INNER JOIN snippets s ON s.id = r.snippet_id
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE r.status = 'open'
+		GROUP BY s.id, s.title, s.user_id, u.username, u.name, s.hidden
+		ORDER BY MIN(r.created)
+	`
+	rows, err := m.DB.Query(query)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*ReportedSnippet{}
+	for rows.Next() {
+		s := &ReportedSnippet{}
+		err := rows.Scan(
+			&s.SnippetID,
+			&s.Title,
+			&s.AuthorID,
+			&s.Author,
+			&s.Hidden,
+			&s.Reports,
+			&s.Categories,
+			&s.FirstReported,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// return the open reports about a snippet, oldest first
+func (m *ReportModel) ForSnippet(snippetID int) ([]*Report, error) {
+	query := `
+		SELECT r.id, r.snippet_id, r.user_id, COALESCE(u.name, ''), r.category, r.details, r.status, r.created
+		FROM reports r
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.snippet_id = ? AND r.status = 'open'
+		ORDER BY r.id
+	`
+	rows, err := m.DB.Query(query, snippetID)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := []*Report{}
+	for rows.Next() {
+		r := &Report{}
+		err := rows.Scan(
+			&r.ID,
+			&r.SnippetID,
+			&r.UserID,
+			&r.UserName,
+			&r.Category,
+			&r.Details,
+			&r.Status,
+			&r.Created,
+		)
+		if err!=nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// close all open reports about a snippet with status
+func (m *ReportModel) Resolve(snippetID int, status string) error {
+	query := `
+		UPDATE reports
+		SET status = ?
+		WHERE snippet_id = ? AND status = 'open'
+	`
+	_, err := m.DB.Exec(query, status, snippetID)
+	return err
+}
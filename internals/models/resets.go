@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// password resets hold single-use tokens sent to users who forgot their password
+// only the SHA-256 hash of a token is stored so a leaked table can not be used to reset passwords
+//
+//	CREATE TABLE password_resets (
+//		token_hash CHAR(64) NOT NULL PRIMARY KEY,
+//		user_id INTEGER NOT NULL,
+//		expires DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_password_resets_user_id ON password_resets(user_id);
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// store the hash of a new reset token for user which is valid for ttl
+func (m *PasswordResetModel) Insert(userID int, tokenHash string, ttl time.Duration) error {
+	// clean up expired tokens while we are here
+	_, err := m.DB.Exec(`DELETE FROM password_resets WHERE expires <= UTC_TIMESTAMP()`)
+	if err!=nil {
+		return err
+	}
+	query := `
+		INSERT INTO password_resets (token_hash, user_id, expires)
+		VALUES (?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))
+	`
+	_, err = m.DB.Exec(query, tokenHash, userID, int(ttl.Seconds()))
+	return err
+}
+
+// return the user a reset token belongs to without using it up
+// returns ErrNoRecord if the token does not exist or has expired
+func (m *PasswordResetModel) GetUserID(tokenHash string) (int, error) {
+	query := `
+		SELECT user_id
+		FROM password_resets
+		WHERE token_hash = ? AND expires > UTC_TIMESTAMP()
+	`
+	var userID int
+	err := m.DB.QueryRow(query, tokenHash).Scan(&userID)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return userID, nil
+}
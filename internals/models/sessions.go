@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// devices a user is logged in on, one row for each session in the scs sessions table
+// rows whose session has expired or was deleted are cleaned up when the user's sessions are listed
+//
+//	CREATE TABLE user_sessions (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		token CHAR(43) NOT NULL,
+//		user_id INTEGER NOT NULL,
+//		user_agent VARCHAR(255) NOT NULL,
+//		ip VARCHAR(45) NOT NULL,
+//		created DATETIME NOT NULL,
+//		last_seen DATETIME NOT NULL
+//	);
+//	CREATE UNIQUE INDEX idx_user_sessions_token ON user_sessions(token);
+//	CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
+//
+// remember_me is set for sessions with a persistent cookie which outlive the browser
+//
+//	ALTER TABLE user_sessions ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
+type UserSession struct {
+	ID int
+	Token string
+	UserID int
+	UserAgent string
+	IP string
+	RememberMe bool
+	Created time.Time
+	LastSeen time.Time
+}
+
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+// record a new session of a user
+func (m *UserSessionModel) Insert(token string, userID int, userAgent, ip string, rememberMe bool) error {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	query := `
+		INSERT INTO user_sessions (token, user_id, user_agent, ip, remember_me, created, last_seen)
+		VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())
+	`
+	_, err := m.DB.Exec(query, token, userID, userAgent, ip, rememberMe)
+	return err
+}
+
+// update when and from where a session was last used
+// the row is written at most once a minute to keep requests cheap
+func (m *UserSessionModel) Touch(token, ip string) error {
+	query := `
+		UPDATE user_sessions
+		SET last_seen = UTC_TIMESTAMP(), ip = ?
+		WHERE token = ? AND (last_seen < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE) OR ip <> ?)
+	`
+	_, err := m.DB.Exec(query, ip, token, ip)
+	return err
+}
+
+// return the live sessions of a user, most recently used first
+func (m *UserSessionModel) ForUser(userID int) ([]*UserSession, error) {
+	// forget sessions which have expired or were deleted from the session store
+	query := `
+		DELETE us FROM user_sessions us
+		LEFT JOIN sessions s ON s.token = us.token
+		WHERE us.user_id = ? AND (s.token IS NULL OR s.expiry < UTC_TIMESTAMP(6))
+	`
+	_, err := m.DB.Exec(query, userID)
+	if err!=nil {
+		return nil, err
+	}
+	query = `
+		SELECT id, token, user_id, user_agent, ip, remember_me, created, last_seen
+		FROM user_sessions
+		WHERE user_id = ?
+		ORDER BY last_seen DESC
+	`
+	rows, err := m.DB.Query(query, userID)
+	if err!=nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*UserSession{}
+	for rows.Next() {
+		s := &UserSession{}
+		err = rows.Scan(&s.ID, &s.Token, &s.UserID, &s.UserAgent, &s.IP, &s.RememberMe, &s.Created, &s.LastSeen)
+		if err!=nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// forget a session
+func (m *UserSessionModel) Delete(token string) error {
+	_, err := m.DB.Exec(`DELETE FROM user_sessions WHERE token = ?`, token)
+	return err
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// site settings changed by admins
+const (
+	// refuse to save snippets which contain secrets instead of warning the author
+	SettingBlockSecrets = "block_secrets"
+)
+
+// site settings which admins change while the app is running
+// a setting which has never been saved has its default value
+//
+//	CREATE TABLE settings (
+//		name VARCHAR(100) NOT NULL PRIMARY KEY,
+//		value TEXT NOT NULL,
+//		updated DATETIME NOT NULL
+//	);
+type SettingModel struct {
+	DB *sql.DB
+}
+
+// return the value of a setting, or "" if it has never been saved
+func (m *SettingModel) Get(name string) (string, error) {
+	var value string
+	query := `SELECT value FROM settings WHERE name = ?`
+	err := m.DB.QueryRow(query, name).Scan(&value)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+// save the value of a setting
+func (m *SettingModel) Set(name, value string) error {
+	query := `
+		INSERT INTO settings (name, value, updated) VALUES (?, ?, UTC_TIMESTAMP())
+		ON DUPLICATE KEY UPDATE value = VALUES(value), updated = VALUES(updated)
+	`
+	_, err := m.DB.Exec(query, name, value)
+	return err
+}
+
+// return a setting stored with SetBool, false if it has never been saved
+func (m *SettingModel) GetBool(name string) (bool, error) {
+	value, err := m.Get(name)
+	if err!=nil {
+		return false, err
+	}
+	return value=="true", nil
+}
+
+func (m *SettingModel) SetBool(name string, value bool) error {
+	if value {
+		return m.Set(name, "true")
+	}
+	return m.Set(name, "false")
+}
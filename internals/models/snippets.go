@@ -1,32 +1,67 @@
 package models
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"time"
 )
 
+// snippets are owned by the user who created them
+// snippets created before ownership was added have user_id 0
+//
+//	ALTER TABLE snippets ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+//
+// moderators hide reported snippets pending review
+// hidden snippets are left out everywhere except the moderation and admin areas
+//
+//	ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+//
+// content is shown as code highlighted for its language or rendered as markdown
+//
+//	ALTER TABLE snippets ADD COLUMN format VARCHAR(10) NOT NULL DEFAULT 'code';
+//	ALTER TABLE snippets ADD COLUMN language VARCHAR(30) NOT NULL DEFAULT '';
 type Snippet struct {
 	ID int
+	UserID int
 	Title string
 	Content string
+	Format string
+	// language code is highlighted as, e.g. go, "" for plain text
+	Language string
 	Created time.Time
 	Expires time.Time
+	// number of users who starred the snippet
+	Stars int
+	Hidden bool
+}
+
+// identify the content of the snippet, line comments are attached to a revision
+func (s *Snippet) Revision() string {
+	sum := sha256.Sum256([]byte(s.Content))
+	return hex.EncodeToString(sum[:])
 }
 
 type SnippetModel struct {
 	DB *sql.DB
 }
 
+// formats snippet content can be shown in
+const (
+	FormatCode = "code"
+	FormatMarkdown = "markdown"
+)
+
 // insert a new snippet into the db
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(title string, content string, format string, language string, expires int, userID int) (int, error) {
 	// create a sql query with placeholders (?) for user input data
 	query := `
-		INSERT INTO snippets (title, content, created, expires)
-		VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))
+		INSERT INTO snippets (user_id, title, content, format, language, created, expires)
+		VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))
 	`
 	// call query with params using db exec
-	result, err := m.DB.Exec(query, title, content, expires)
+	result, err := m.DB.Exec(query, userID, title, content, format, language, expires)
 	if err!=nil {
 		return 0, err
 	}
@@ -42,10 +77,12 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	// create sql with placeholders
 	query := `
-		SELECT id, title, content, created, expires
+		SELECT id, user_id, title, content, format, language, created, expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
 		FROM snippets
 		WHERE
 			expires > UTC_TIMESTAMP() AND
+			NOT hidden AND
 			id = ?
 	`
 	// QueryRow: returns the first row and ignores rest
@@ -54,10 +91,44 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	s := &Snippet{}
 	err := row.Scan(
 		&s.ID,
+		&s.UserID,
+		&s.Title,
+		&s.Content,
+		&s.Format,
+		&s.Language,
+		&s.Created,
+		&s.Expires,
+		&s.Stars,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// return a snippet even if it has been hidden or has expired, for moderators
+func (m *SnippetModel) GetForModeration(id int) (*Snippet, error) {
+	query := `
+		SELECT id, user_id, title, content, format, language, created, expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id), hidden
+		FROM snippets
+		WHERE id = ?
+	`
+	s := &Snippet{}
+	err := m.DB.QueryRow(query, id).Scan(
+		&s.ID,
+		&s.UserID,
 		&s.Title,
 		&s.Content,
+		&s.Format,
+		&s.Language,
 		&s.Created,
 		&s.Expires,
+		&s.Stars,
+		&s.Hidden,
 	)
 	if err!=nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -68,17 +139,112 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
+// check if a snippet which has not expired is hidden
+func (m *SnippetModel) IsHidden(id int) (bool, error) {
+	var hidden bool
+	query := `
+		SELECT hidden
+		FROM snippets
+		WHERE expires > UTC_TIMESTAMP() AND id = ?
+	`
+	err := m.DB.QueryRow(query, id).Scan(&hidden)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+	return hidden, nil
+}
+
+// hide or show a snippet
+func (m *SnippetModel) SetHidden(id int, hidden bool) error {
+	query := `
+		UPDATE snippets
+		SET hidden = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(query, hidden, id)
+	return err
+}
+
 // return the 10 most recently created snippets
 func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	// create sql query
 	query := `
-		SELECT id, title, content, created, expires
+		SELECT id, user_id, title, content, created, expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
 		FROM snippets
-		WHERE expires > UTC_TIMESTAMP()
+		WHERE expires > UTC_TIMESTAMP() AND NOT hidden
 		ORDER BY id DESC LIMIT 10
 	`
+	return m.list(query)
+}
+
+// return the 10 snippets with the most stars
+func (m *SnippetModel) Popular() ([]*Snippet, error) {
+	query := `
+		SELECT id, user_id, title, content, created, expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id) AS star_count
+		FROM snippets
+		WHERE expires > UTC_TIMESTAMP() AND NOT hidden
+		ORDER BY star_count DESC, id DESC LIMIT 10
+	`
+	return m.list(query)
+}
+
+// return the snippets starred by a user which have not expired, most recently starred first
+func (m *SnippetModel) StarredBy(userID int) ([]*Snippet, error) {
+	query := `
+		SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = s.id)
+		FROM snippets s
+		INNER JOIN stars st ON st.snippet_id = s.id
+		WHERE
+			st.user_id = ? AND
+			s.expires > UTC_TIMESTAMP() AND
+			NOT s.hidden
+		ORDER BY st.created DESC
+	`
+	return m.list(query, userID)
+}
+
+// return the snippets created by a user which have not expired, newest first
+func (m *SnippetModel) ByUser(userID int) ([]*Snippet, error) {
+	query := `
+		SELECT id, user_id, title, content, created, expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id)
+		FROM snippets
+		WHERE
+			user_id = ? AND
+			expires > UTC_TIMESTAMP() AND
+			NOT hidden
+		ORDER BY id DESC
+	`
+	return m.list(query, userID)
+}
+
+// return the snippets in a collection which have not expired, in the order of the collection
+func (m *SnippetModel) InCollection(collectionID int) ([]*Snippet, error) {
+	query := `
+		SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = s.id)
+		FROM snippets s
+		INNER JOIN collection_snippets cs ON cs.snippet_id = s.id
+		WHERE
+			cs.collection_id = ? AND
+			s.expires > UTC_TIMESTAMP() AND
+			NOT s.hidden
+		ORDER BY cs.position
+	`
+	return m.list(query, collectionID)
+}
+
+// run a query which selects id, user_id, title, content, created, expires and the star count
+// and map the rows to snippets
+func (m *SnippetModel) list(query string, args ...any) ([]*Snippet, error) {
 	// get rows from db using query
-	rows, err := m.DB.Query(query)
+	rows, err := m.DB.Query(query, args...)
 	if err!=nil {
 		return nil, err
 	}
@@ -89,10 +255,12 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 		s := &Snippet{}
 		err := rows.Scan(
 			&s.ID,
+			&s.UserID,
 			&s.Title,
 			&s.Content,
 			&s.Created,
 			&s.Expires,
+			&s.Stars,
 		)
 		if err!=nil {
 			return nil, err
@@ -104,3 +272,120 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	}
 	return snippets, nil
 }
+
+// a snippet listed in the admin area together with its author
+type AuthoredSnippet struct {
+	Snippet
+	// username of the author, or their name if they have no username
+	Author string
+}
+
+// filters for the list of snippets in the admin area, empty fields match every snippet
+type SnippetFilter struct {
+	// part of the title
+	Search string
+	// username or email address of the author
+	Author string
+	// live or expired
+	Status string
+	// starts at 1
+	Page int
+}
+
+// return a page of snippets matching filter, including expired ones, newest first
+// the returned bool tells if there are more pages
+func (m *SnippetModel) List(filter SnippetFilter, pageSize int) ([]*AuthoredSnippet, bool, error) {
+	query := `
+		SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires,
+			(SELECT COUNT(*) FROM stars WHERE stars.snippet_id = s.id),
+			s.hidden, COALESCE(u.username, u.name, '')
+		FROM snippets s
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE 1 = 1
+	`
+	args := []any{}
+	if filter.Search!="" {
+		query += ` AND s.title LIKE ?`
+		args = append(args, "%" + escapeLike(filter.Search) + "%")
+	}
+	if filter.Author!="" {
+		query += ` AND (u.username = ? OR u.email = ?)`
+		args = append(args, filter.Author, filter.Author)
+	}
+	switch filter.Status {
+	case "live":
+		query += ` AND s.expires > UTC_TIMESTAMP()`
+	case "expired":
+		query += ` AND s.expires <= UTC_TIMESTAMP()`
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	// fetch one more row than needed to know if there is another page
+	query += ` ORDER BY s.id DESC LIMIT ? OFFSET ?`
+	args = append(args, pageSize+1, (filter.Page-1)*pageSize)
+	rows, err := m.DB.Query(query, args...)
+	if err!=nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	snippets := []*AuthoredSnippet{}
+	for rows.Next() {
+		s := &AuthoredSnippet{}
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.Title,
+			&s.Content,
+			&s.Created,
+			&s.Expires,
+			&s.Stars,
+			&s.Hidden,
+			&s.Author,
+		)
+		if err!=nil {
+			return nil, false, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, false, err
+	}
+	if len(snippets) > pageSize {
+		return snippets[:pageSize], true, nil
+	}
+	return snippets, false, nil
+}
+
+// delete a snippet together with its comments, stars and collection entries
+func (m *SnippetModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err!=nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`DELETE FROM comments WHERE snippet_id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM stars WHERE snippet_id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collection_snippets WHERE snippet_id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err!=nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err!=nil {
+		return err
+	}
+	if rows==0 {
+		return ErrNoRecord
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// stars are the snippets users have marked as favourites
+//
+//	CREATE TABLE stars (
+//		user_id INTEGER NOT NULL,
+//		snippet_id INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		PRIMARY KEY (user_id, snippet_id)
+//	);
+//	CREATE INDEX idx_stars_snippet_id ON stars(snippet_id);
+type StarModel struct {
+	DB *sql.DB
+}
+
+// set whether a user has starred a snippet
+// setting the same value again has no effect so repeated requests are safe
+func (m *StarModel) Set(userID, snippetID int, starred bool) error {
+	query := `
+		DELETE FROM stars
+		WHERE user_id = ? AND snippet_id = ?
+	`
+	if starred {
+		query = `
+			INSERT IGNORE INTO stars (user_id, snippet_id, created)
+			VALUES (?, ?, UTC_TIMESTAMP())
+		`
+	}
+	_, err := m.DB.Exec(query, userID, snippetID)
+	return err
+}
+
+// check if a user has starred a snippet
+func (m *StarModel) Exists(userID, snippetID int) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)
+	`
+	err := m.DB.QueryRow(query, userID, snippetID).Scan(&exists)
+	return exists, err
+}
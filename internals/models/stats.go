@@ -0,0 +1,51 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// counts shown on the admin dashboard
+type Stats struct {
+	Users int
+	DisabledUsers int
+	Admins int
+	Moderators int
+	// users who signed up in the last 7 days
+	NewUsers int
+	Snippets int
+	LiveSnippets int
+	Comments int
+}
+
+type StatsModel struct {
+	DB *sql.DB
+}
+
+func (m *StatsModel) Get() (*Stats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE disabled),
+			(SELECT COUNT(*) FROM users WHERE role = ?),
+			(SELECT COUNT(*) FROM users WHERE role = ?),
+			(SELECT COUNT(*) FROM users WHERE created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL 7 DAY)),
+			(SELECT COUNT(*) FROM snippets),
+			(SELECT COUNT(*) FROM snippets WHERE expires > UTC_TIMESTAMP()),
+			(SELECT COUNT(*) FROM comments)
+	`
+	var s Stats
+	err := m.DB.QueryRow(query, RoleAdmin, RoleModerator).Scan(
+		&s.Users,
+		&s.DisabledUsers,
+		&s.Admins,
+		&s.Moderators,
+		&s.NewUsers,
+		&s.Snippets,
+		&s.LiveSnippets,
+		&s.Comments,
+	)
+	if err!=nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -7,39 +7,141 @@ import (
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
+	"snippetbox.anukuljoshi/internals/passwords"
 )
 
+// users choose a unique username which is used for their public profile
+// users who signed up before usernames were added have a NULL username
+// timezone is an IANA time zone name used to show dates to the user
+//
+//	ALTER TABLE users ADD COLUMN username VARCHAR(30) NULL;
+//	ALTER TABLE users ADD CONSTRAINT users_uc_username UNIQUE (username);
+//	ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+//
+// email_verified is set once the user follows the link sent to their email address
+// users who signed up before verification was added are treated as verified
+//
+//	ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+//	UPDATE users SET email_verified = TRUE;
+//
+// session_epoch is stored in a user's sessions on login
+// incrementing it logs the user out of all existing sessions
+//
+//	ALTER TABLE users ADD COLUMN session_epoch INTEGER NOT NULL DEFAULT 0;
+//
+// totp_secret is set when the user has enabled two-factor authentication
+// totp_last_step is the time step of the last accepted code so a code can not be used twice
+//
+//	ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+//	ALTER TABLE users ADD COLUMN totp_last_step BIGINT NULL;
+//
+// hashed_password holds bcrypt hashes of older passwords or argon2id hashes, which are longer
+//
+//	ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
+//
+// role is one of user, moderator or admin
+// the first admin has to be made in the database, after that admins can promote users
+// in the admin area, e.g. UPDATE users SET role = 'admin' WHERE email = 'admin@example.com';
+//
+//	ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+//
+// disabled users can not log in, admins disable accounts from the admin area
+//
+//	ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
 type User struct {
 	ID int
 	Name string
+	Username string
 	Email string
+	EmailVerified bool
+	Timezone string
+	TOTPEnabled bool
+	Role string
+	Disabled bool
 	HashedPassword []byte
 	Created time.Time
 }
 
+// roles in order of increasing privileges
+const (
+	RoleUser = "user"
+	RoleModerator = "moderator"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// return how privileged a role is, higher roles include the privileges of lower ones
+// returns -1 for unknown roles
+func RoleLevel(role string) int {
+	for i, r := range Roles {
+		if r==role {
+			return i
+		}
+	}
+	return -1
+}
+
+// the public part of a user which can be shown to anyone
+// it must never contain the user's email address
+type Profile struct {
+	ID int
+	Name string
+	Username string
+	Created time.Time
+}
+
 type UserModel struct {
 	DB *sql.DB
+	// hashes passwords, uses argon2id with the default params if nil
+	Hasher *passwords.Hasher
+}
+
+func (u *UserModel) hasher() *passwords.Hasher {
+	if u.Hasher==nil {
+		return passwords.New(passwords.DefaultParams)
+	}
+	return u.Hasher
+}
+
+// check password against a stored hash
+// returns ErrInvalidCredentials if the password is wrong
+func (u *UserModel) verifyPassword(password string, hashedPassword []byte) error {
+	// users who signed up through single sign-on have no password
+	if len(hashedPassword)==0 {
+		return ErrInvalidCredentials
+	}
+	err := u.hasher().Verify(password, string(hashedPassword))
+	if err!=nil {
+		if errors.Is(err, passwords.ErrMismatch) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
 }
 
 // insert a new user to db
-func (u *UserModel) Insert(name, email, password string) (int, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (u *UserModel) Insert(name, username, email, password string) (int, error) {
+	hashedPassword, err := u.hasher().Hash(password)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	query := `
-		INSERT INTO users (name, email, hashed_password, created)
-		VALUES (?, ?, ?, UTC_TIMESTAMP())
+		INSERT INTO users (name, username, email, hashed_password, created)
+		VALUES (?, ?, ?, ?, UTC_TIMESTAMP())
 	`
-	result, err := u.DB.Exec(query, name, email, hashedPassword)
+	result, err := u.DB.Exec(query, name, username, email, hashedPassword)
 	if err!=nil {
-		// check if error is due to duplicate email
+		// check if error is due to duplicate email or username
 		var mySqlError *mysql.MySQLError
 		if errors.As(err, &mySqlError) {
 			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_email") {
 				return 0, ErrDuplicateEmail
 			}
+			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_username") {
+				return 0, ErrDuplicateUsername
+			}
 		}
 		return 0, err
 	}
@@ -50,6 +152,34 @@ func (u *UserModel) Insert(name, email, password string) (int, error) {
 	return int(id), nil
 }
 
+// insert a user who signed up through an external identity provider
+// the user has no password, so they can only log in through the provider
+// the email address is trusted if the provider verified it
+func (u *UserModel) InsertExternal(name, username, email string, emailVerified bool) (int, error) {
+	query := `
+		INSERT INTO users (name, username, email, email_verified, hashed_password, created)
+		VALUES (?, NULLIF(?, ''), ?, ?, '', UTC_TIMESTAMP())
+	`
+	result, err := u.DB.Exec(query, name, username, email, emailVerified)
+	if err!=nil {
+		var mySqlError *mysql.MySQLError
+		if errors.As(err, &mySqlError) {
+			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_username") {
+				return 0, ErrDuplicateUsername
+			}
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err!=nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
 // authenticate a user with email and password
 func (u *UserModel) Authenticate(email, password string) (int, error) {
 	query := `
@@ -67,13 +197,26 @@ func (u *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 	// check if password is correct
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	err = u.verifyPassword(password, hashedPassword)
 	if err!=nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		}
 		return 0, err
 	}
+	// upgrade hashes made with an older algorithm or older params now that we know the password
+	if u.hasher().NeedsRehash(string(hashedPassword)) {
+		newHashedPassword, err := u.hasher().Hash(password)
+		if err!=nil {
+			return 0, err
+		}
+		query = `
+			UPDATE users
+			SET hashed_password = ?
+			WHERE id = ? AND hashed_password = ?
+		`
+		_, err = u.DB.Exec(query, newHashedPassword, id, hashedPassword)
+		if err!=nil {
+			return 0, err
+		}
+	}
 	return id, nil
 }
 
@@ -89,7 +232,7 @@ func (u *UserModel) Exists(id int) (bool, error) {
 
 func (u *UserModel) Get(id int) (*User, error) {
 	query := `
-		SELECT id, name, email, created
+		SELECT id, name, COALESCE(username, ''), email, email_verified, timezone, totp_secret IS NOT NULL, role, disabled, created
 		FROM users
 		WHERE id = ?
 	`
@@ -97,7 +240,13 @@ func (u *UserModel) Get(id int) (*User, error) {
 	err := u.DB.QueryRow(query, id).Scan(
 		&user.ID,
 		&user.Name,
+		&user.Username,
 		&user.Email,
+		&user.EmailVerified,
+		&user.Timezone,
+		&user.TOTPEnabled,
+		&user.Role,
+		&user.Disabled,
 		&user.Created,
 	)
 	if err!=nil {
@@ -109,6 +258,53 @@ func (u *UserModel) Get(id int) (*User, error) {
 	return &user, nil
 }
 
+// return the public profile of a user based on id
+// users without a username do not have a profile
+func (u *UserModel) GetProfile(id int) (*Profile, error) {
+	query := `
+		SELECT id, name, username, created
+		FROM users
+		WHERE id = ? AND username IS NOT NULL
+	`
+	var profile Profile
+	err := u.DB.QueryRow(query, id).Scan(
+		&profile.ID,
+		&profile.Name,
+		&profile.Username,
+		&profile.Created,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// return the public profile of a user based on username
+func (u *UserModel) GetProfileByUsername(username string) (*Profile, error) {
+	query := `
+		SELECT id, name, username, created
+		FROM users
+		WHERE username = ?
+	`
+	var profile Profile
+	err := u.DB.QueryRow(query, username).Scan(
+		&profile.ID,
+		&profile.Name,
+		&profile.Username,
+		&profile.Created,
+	)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
 // insert a new user to db
 func (u *UserModel) UpdatePassword(id int, currentPassword, newPassword string) error {
 	query := `
@@ -123,22 +319,459 @@ func (u *UserModel) UpdatePassword(id int, currentPassword, newPassword string)
 	if err != nil {
 		return err
 	}
-	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(currentPassword))
+	err = u.verifyPassword(currentPassword, user.HashedPassword)
 	if err!=nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return err
+	}
+	newHashedPassword, err := u.hasher().Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	// changing the password logs the user out of all existing sessions
+	query = `
+		UPDATE users
+		SET hashed_password = ?, session_epoch = session_epoch + 1
+		WHERE id = ?
+	`
+	_, err = u.DB.Exec(query, newHashedPassword, id)
+	return err
+}
+
+// update the profile details of a user
+func (u *UserModel) UpdateProfile(id int, name, username, timezone string) error {
+	query := `
+		UPDATE users
+		SET name = ?, username = ?, timezone = ?
+		WHERE id = ?
+	`
+	_, err := u.DB.Exec(query, name, username, timezone, id)
+	if err!=nil {
+		var mySqlError *mysql.MySQLError
+		if errors.As(err, &mySqlError) {
+			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_username") {
+				return ErrDuplicateUsername
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// check the password of a user
+// returns ErrInvalidCredentials if the password is wrong
+func (u *UserModel) CheckPassword(id int, password string) error {
+	query := `
+		SELECT hashed_password
+		FROM users
+		WHERE id = ?
+	`
+	var hashedPassword []byte
+	err := u.DB.QueryRow(query, id).Scan(&hashedPassword)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
 			return ErrInvalidCredentials
 		}
 		return err
 	}
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
-	if err != nil {
+	return u.verifyPassword(password, hashedPassword)
+}
+
+// check that no user has the email address
+// returns ErrDuplicateEmail if it is already in use, like Insert and UpdateEmail do
+func (u *UserModel) EmailAvailable(email string) error {
+	var exists bool
+	query := `
+		SELECT EXISTS (SELECT true FROM users WHERE email = ?)
+	`
+	err := u.DB.QueryRow(query, email).Scan(&exists)
+	if err!=nil {
 		return err
 	}
+	if exists {
+		return ErrDuplicateEmail
+	}
+	return nil
+}
+
+// change the email address of a user from currentEmail to newEmail
+// the new address is verified as the change is confirmed through a link sent to it
+// returns ErrNoRecord if the user's email is no longer currentEmail
+func (u *UserModel) UpdateEmail(id int, currentEmail, newEmail string) error {
+	query := `
+		UPDATE users
+		SET email = ?, email_verified = TRUE
+		WHERE id = ? AND email = ?
+	`
+	result, err := u.DB.Exec(query, newEmail, id, currentEmail)
+	if err!=nil {
+		var mySqlError *mysql.MySQLError
+		if errors.As(err, &mySqlError) {
+			if mySqlError.Number==1062 && strings.Contains(mySqlError.Message, "users_uc_email") {
+				return ErrDuplicateEmail
+			}
+		}
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err!=nil {
+		return err
+	}
+	if rows==0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// mark the email address of a user as verified
+// returns ErrNoRecord if the user's email is no longer email
+func (u *UserModel) VerifyEmail(id int, email string) error {
+	query := `
+		UPDATE users
+		SET email_verified = TRUE
+		WHERE id = ? AND email = ?
+	`
+	result, err := u.DB.Exec(query, id, email)
+	if err!=nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err!=nil {
+		return err
+	}
+	// rows affected is also 0 if the email was already verified
+	if rows==0 {
+		var exists bool
+		query = `
+			SELECT EXISTS (SELECT true FROM users WHERE id = ? AND email = ?)
+		`
+		err = u.DB.QueryRow(query, id, email).Scan(&exists)
+		if err!=nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+	return nil
+}
+
+// check if the email address of a user has been verified
+func (u *UserModel) EmailVerified(id int) (bool, error) {
+	var verified bool
+	query := `
+		SELECT email_verified
+		FROM users
+		WHERE id = ?
+	`
+	err := u.DB.QueryRow(query, id).Scan(&verified)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+	return verified, nil
+}
+
+// return the session epoch of a user
+// sessions which were created with a different epoch have been revoked
+func (u *UserModel) SessionEpoch(id int) (int, error) {
+	var epoch int
+	query := `
+		SELECT session_epoch
+		FROM users
+		WHERE id = ?
+	`
+	err := u.DB.QueryRow(query, id).Scan(&epoch)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return epoch, nil
+}
+
+// what the authenticate middleware checks on every request of a logged in user
+type SessionInfo struct {
+	Epoch int
+	Role string
+	Disabled bool
+}
+
+// return the session epoch, role and status of a user in one query
+func (u *UserModel) SessionInfo(id int) (*SessionInfo, error) {
+	var info SessionInfo
+	query := `
+		SELECT session_epoch, role, disabled
+		FROM users
+		WHERE id = ?
+	`
+	err := u.DB.QueryRow(query, id).Scan(&info.Epoch, &info.Role, &info.Disabled)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &info, nil
+}
+
+// log a user out of all existing sessions
+func (u *UserModel) RevokeSessions(id int) error {
+	query := `
+		UPDATE users
+		SET session_epoch = session_epoch + 1
+		WHERE id = ?
+	`
+	_, err := u.DB.Exec(query, id)
+	return err
+}
+
+// return the id of the user with email
+func (u *UserModel) GetIDByEmail(email string) (int, error) {
+	var id int
+	query := `
+		SELECT id
+		FROM users
+		WHERE email = ?
+	`
+	err := u.DB.QueryRow(query, email).Scan(&id)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// set a new password for a user who forgot theirs with a reset token from password_resets
+// and revoke all of their sessions
+// the token is only used up if the password is changed, so a failure leaves the link working
+// returns the id of the user, or ErrNoRecord if the token does not exist, has expired or was already used
+func (u *UserModel) ResetPassword(tokenHash, newPassword string) (int, error) {
+	hashedPassword, err := u.hasher().Hash(newPassword)
+	if err!=nil {
+		return 0, err
+	}
+	tx, err := u.DB.Begin()
+	if err!=nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	query := `
+		SELECT user_id
+		FROM password_resets
+		WHERE token_hash = ? AND expires > UTC_TIMESTAMP()
+		FOR UPDATE
+	`
+	var id int
+	err = tx.QueryRow(query, tokenHash).Scan(&id)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	// all other reset tokens of the user are deleted as well
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id)
+	if err!=nil {
+		return 0, err
+	}
 	query = `
 		UPDATE users
-		SET hashed_password = ?
+		SET hashed_password = ?, session_epoch = session_epoch + 1
+		WHERE id = ?
+	`
+	_, err = tx.Exec(query, hashedPassword, id)
+	if err!=nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err!=nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// return the two-factor secret of a user
+// returns an empty string if the user has not enabled two-factor authentication
+func (u *UserModel) TOTPSecret(id int) (string, error) {
+	var secret sql.NullString
+	query := `
+		SELECT totp_secret
+		FROM users
+		WHERE id = ?
+	`
+	err := u.DB.QueryRow(query, id).Scan(&secret)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// turn on two-factor authentication for a user
+func (u *UserModel) EnableTOTP(id int, secret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = ?, totp_last_step = NULL
+		WHERE id = ?
+	`
+	_, err := u.DB.Exec(query, secret, id)
+	return err
+}
+
+// turn off two-factor authentication for a user
+func (u *UserModel) DisableTOTP(id int) error {
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_last_step = NULL
 		WHERE id = ?
 	`
-	_, err = u.DB.Exec(query, string(newHashedPassword), id)
+	_, err := u.DB.Exec(query, id)
 	return err
 }
+
+// record that the code for time step was used
+// returns false if a code for this or a later step was already used
+func (u *UserModel) UseTOTPStep(id int, step uint64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = ?
+		WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)
+	`
+	result, err := u.DB.Exec(query, step, id, step)
+	if err!=nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err!=nil {
+		return false, err
+	}
+	return rows==1, nil
+}
+
+// change the role of a user
+func (u *UserModel) SetRole(id int, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?
+		WHERE id = ?
+	`
+	_, err := u.DB.Exec(query, role, id)
+	return err
+}
+
+// check if an admin has disabled the account of a user
+func (u *UserModel) Disabled(id int) (bool, error) {
+	var disabled bool
+	query := `
+		SELECT disabled
+		FROM users
+		WHERE id = ?
+	`
+	err := u.DB.QueryRow(query, id).Scan(&disabled)
+	if err!=nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+	return disabled, nil
+}
+
+// disable or enable the account of a user
+// disabling an account logs the user out of all existing sessions
+func (u *UserModel) SetDisabled(id int, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled = ?, session_epoch = session_epoch + IF(?, 1, 0)
+		WHERE id = ?
+	`
+	_, err := u.DB.Exec(query, disabled, disabled, id)
+	return err
+}
+
+// filters for the list of users in the admin area, empty fields match everyone
+type UserFilter struct {
+	// part of the name, username or email address
+	Search string
+	Role string
+	// active or disabled
+	Status string
+	// starts at 1
+	Page int
+}
+
+// return a page of users matching filter, newest first
+// the returned bool tells if there are more pages
+func (u *UserModel) List(filter UserFilter, pageSize int) ([]*User, bool, error) {
+	query := `
+		SELECT id, name, COALESCE(username, ''), email, email_verified, timezone, totp_secret IS NOT NULL, role, disabled, created
+		FROM users
+		WHERE 1 = 1
+	`
+	args := []any{}
+	if filter.Search!="" {
+		query += ` AND (name LIKE ? OR username LIKE ? OR email LIKE ?)`
+		like := "%" + escapeLike(filter.Search) + "%"
+		args = append(args, like, like, like)
+	}
+	if filter.Role!="" {
+		query += ` AND role = ?`
+		args = append(args, filter.Role)
+	}
+	switch filter.Status {
+	case "active":
+		query += ` AND NOT disabled`
+	case "disabled":
+		query += ` AND disabled`
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	// fetch one more row than needed to know if there is another page
+	query += ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	args = append(args, pageSize+1, (filter.Page-1)*pageSize)
+	rows, err := u.DB.Query(query, args...)
+	if err!=nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Name,
+			&user.Username,
+			&user.Email,
+			&user.EmailVerified,
+			&user.Timezone,
+			&user.TOTPEnabled,
+			&user.Role,
+			&user.Disabled,
+			&user.Created,
+		)
+		if err!=nil {
+			return nil, false, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err!=nil {
+		return nil, false, err
+	}
+	if len(users) > pageSize {
+		return users[:pageSize], true, nil
+	}
+	return users, false, nil
+}
+
+// escape the wildcards of a LIKE pattern so they match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,132 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch = errors.New("passwords: password does not match hash")
+	ErrUnknownFormat = errors.New("passwords: unknown hash format")
+)
+
+// Params are the argon2id settings used for new hashes
+// Memory is in KiB
+type Params struct {
+	Time uint32
+	Memory uint32
+	Threads uint8
+	SaltLength uint32
+	KeyLength uint32
+}
+
+// DefaultParams follow the second recommended option of RFC 9106
+var DefaultParams = Params{
+	Time: 3,
+	Memory: 64*1024,
+	Threads: 4,
+	SaltLength: 16,
+	KeyLength: 32,
+}
+
+// Hasher hashes new passwords with argon2id and verifies both argon2id and bcrypt hashes
+// argon2id hashes are encoded in the PHC string format
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+type Hasher struct {
+	Params Params
+}
+
+func New(params Params) *Hasher {
+	return &Hasher{Params: params}
+}
+
+// hash password with the current params
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err!=nil {
+		return "", err
+	}
+	p := h.Params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// check password against an encoded hash
+// returns ErrMismatch if the password is wrong
+func (h *Hasher) Verify(password, encoded string) error {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+	p, salt, key, err := decode(encoded)
+	if err!=nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other)!=1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// check if an encoded hash was made with another algorithm or other params than the current ones
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	p, salt, _, err := decode(encoded)
+	if err!=nil {
+		return true
+	}
+	return p.Time!=h.Params.Time ||
+		p.Memory!=h.Params.Memory ||
+		p.Threads!=h.Params.Threads ||
+		p.KeyLength!=h.Params.KeyLength ||
+		uint32(len(salt))!=h.Params.SaltLength
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	var p Params
+	parts := strings.Split(encoded, "$")
+	if len(parts)!=6 || parts[1]!="argon2id" {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err!=nil || version!=argon2.Version {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err!=nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err!=nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err!=nil || len(key)==0 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
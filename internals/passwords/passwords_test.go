@@ -0,0 +1,72 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+// cheap params to keep the tests fast
+var testParams = Params{Time: 1, Memory: 1024, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHasher(t *testing.T) {
+	h := New(testParams)
+	hash, err := h.Hash("pa$$word")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), true)
+
+	other, err := h.Hash("pa$$word")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), bcrypt.MinCost)
+	if err!=nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		password string
+		hash string
+		want error
+	}{
+		{name: "Argon2id", password: "pa$$word", hash: hash, want: nil},
+		{name: "Argon2id Wrong Password", password: "password", hash: hash, want: ErrMismatch},
+		{name: "Bcrypt", password: "pa$$word", hash: string(bcryptHash), want: nil},
+		{name: "Bcrypt Wrong Password", password: "password", hash: string(bcryptHash), want: ErrMismatch},
+		{name: "Unknown Format", password: "pa$$word", hash: "pa$$word", want: ErrUnknownFormat},
+		{name: "Truncated", password: "pa$$word", hash: hash[:len(hash)-44], want: ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.Verify(tt.password, tt.hash)
+			assert.Equal(t, errors.Is(err, tt.want), true)
+		})
+	}
+
+	// every hash gets its own salt
+	assert.Equal(t, hash==other, false)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	h := New(testParams)
+	hash, err := h.Hash("pa$$word")
+	if err!=nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), bcrypt.MinCost)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	stronger := testParams
+	stronger.Time = 2
+
+	assert.Equal(t, h.NeedsRehash(hash), false)
+	assert.Equal(t, h.NeedsRehash(string(bcryptHash)), true)
+	assert.Equal(t, New(stronger).NeedsRehash(hash), true)
+}
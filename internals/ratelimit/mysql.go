@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// MySQLStore keeps token buckets in a table so that every instance of the app
+// behind a load balancer takes tokens from the same buckets
+// the database clock is used so that instances do not have to agree on the time
+//
+//	CREATE TABLE rate_limits (
+//		name VARCHAR(50) NOT NULL,
+//		bucket VARCHAR(255) NOT NULL,
+//		tokens DOUBLE NOT NULL,
+//		updated DATETIME(6) NOT NULL,
+//		PRIMARY KEY (name, bucket)
+//	);
+//	CREATE INDEX idx_rate_limits_updated ON rate_limits(updated);
+type MySQLStore struct {
+	db *sql.DB
+	// buckets of different limits are kept apart by name
+	name string
+	rate float64
+	burst float64
+	mu sync.Mutex
+	lastSweep time.Time
+}
+
+// create a new MySQLStore for the limit name allowing rate requests per second with bursts of up to burst requests
+func NewMySQLStore(db *sql.DB, name string, rate float64, burst int) *MySQLStore {
+	return &MySQLStore{
+		db: db,
+		name: name,
+		rate: rate,
+		burst: float64(burst),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MySQLStore) Take(key string) (bool, time.Duration, error) {
+	err := s.sweep()
+	if err!=nil {
+		return false, 0, err
+	}
+	tx, err := s.db.Begin()
+	if err!=nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	// create a full bucket the first time a key is seen
+	// then lock the row so that concurrent requests take tokens one after another
+	query := `
+		INSERT IGNORE INTO rate_limits (name, bucket, tokens, updated)
+		VALUES (?, ?, ?, UTC_TIMESTAMP(6))
+	`
+	_, err = tx.Exec(query, s.name, key, s.burst)
+	if err!=nil {
+		return false, 0, err
+	}
+	var tokens float64
+	var elapsed int64
+	query = `
+		SELECT tokens, TIMESTAMPDIFF(MICROSECOND, updated, UTC_TIMESTAMP(6))
+		FROM rate_limits WHERE name = ? AND bucket = ? FOR UPDATE
+	`
+	err = tx.QueryRow(query, s.name, key).Scan(&tokens, &elapsed)
+	if err!=nil {
+		return false, 0, err
+	}
+	// refill bucket for the time elapsed since the last request
+	tokens += float64(elapsed) / 1e6 * s.rate
+	if tokens > s.burst {
+		tokens = s.burst
+	}
+	ok := tokens >= 1
+	if ok {
+		tokens--
+	}
+	query = `UPDATE rate_limits SET tokens = ?, updated = UTC_TIMESTAMP(6) WHERE name = ? AND bucket = ?`
+	_, err = tx.Exec(query, tokens, s.name, key)
+	if err!=nil {
+		return false, 0, err
+	}
+	err = tx.Commit()
+	if err!=nil {
+		return false, 0, err
+	}
+	if !ok {
+		return false, refillTime(tokens, s.rate), nil
+	}
+	return true, 0, nil
+}
+
+// delete buckets which would have been refilled completely
+// so that the table does not grow forever, runs at most once a minute
+func (s *MySQLStore) sweep() error {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	full := int64(s.burst / s.rate * 1e6)
+	query := `
+		DELETE FROM rate_limits
+		WHERE name = ? AND updated < UTC_TIMESTAMP(6) - INTERVAL ? MICROSECOND
+	`
+	_, err := s.db.Exec(query, s.name, full)
+	return err
+}
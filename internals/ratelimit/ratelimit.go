@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a set of token buckets, one for each key, which all share a rate and burst
+// Limiter keeps the buckets in memory, MySQLStore keeps them in a table
+// so that several instances of the app share them
+type Store interface {
+	// take a token from the bucket for key
+	// if the bucket is empty it returns false and how long until the next token is added
+	Take(key string) (bool, time.Duration, error)
+}
+
+// Limiter is an in-memory token bucket rate limiter
+// each key gets its own bucket which holds at most burst tokens
+// and is refilled at rate tokens per second
+type Limiter struct {
+	rate float64
+	burst float64
+	mu sync.Mutex
+	buckets map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last time.Time
+}
+
+// create a new Limiter allowing rate requests per second with bursts of up to burst requests
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate: rate,
+		burst: float64(burst),
+		buckets: make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// take a token from the bucket for key
+// if the bucket is empty it returns false and how long until the next token is added
+func (l *Limiter) Take(key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	// refill bucket for the time elapsed since the last request
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, refillTime(b.tokens, l.rate), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// remove buckets which would have been refilled completely
+// so that the map does not grow forever, runs at most once a minute
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens + now.Sub(b.last).Seconds() * l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// time until a bucket holding tokens has a whole token again
+func refillTime(tokens, rate float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / rate * float64(time.Second)))
+}
+
+// ParseRate parses a limit such as "10/m", which allows bursts of 10 requests
+// and refills the bucket completely in a minute, i.e. one token every 6 seconds
+// the period is s, m or h, "" and "0" mean no limit and return a burst of 0
+func ParseRate(s string) (float64, int, error) {
+	if s=="" || s=="0" {
+		return 0, 0, nil
+	}
+	count, unit, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("ratelimit: invalid rate %q, expected e.g. 10/m", s)
+	}
+	burst, err := strconv.Atoi(count)
+	if err!=nil || burst < 1 {
+		return 0, 0, fmt.Errorf("ratelimit: invalid rate %q, expected e.g. 10/m", s)
+	}
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return 0, 0, errors.New("ratelimit: rate period must be s, m or h")
+	}
+	return float64(burst) / period.Seconds(), burst, nil
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestTake(t *testing.T) {
+	l := New(0.5, 2)
+	for i := 0; i < 2; i++ {
+		ok, _, err := l.Take("a")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, ok, true)
+	}
+	ok, retryAfter, err := l.Take("a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+	// one token is added every 2 seconds
+	assert.Equal(t, retryAfter > time.Second && retryAfter <= 2*time.Second, true)
+	// other keys have their own bucket
+	ok, _, _ = l.Take("b")
+	assert.Equal(t, ok, true)
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct{
+		name string
+		limit string
+		rate float64
+		burst int
+		valid bool
+	} {
+		{
+			name: "Per Second",
+			limit: "5/s",
+			rate: 5,
+			burst: 5,
+			valid: true,
+		},
+		{
+			name: "Per Minute",
+			limit: "30/m",
+			rate: 0.5,
+			burst: 30,
+			valid: true,
+		},
+		{
+			name: "Per Hour",
+			limit: "3600/h",
+			rate: 1,
+			burst: 3600,
+			valid: true,
+		},
+		{
+			name: "Off",
+			limit: "0",
+			valid: true,
+		},
+		{
+			name: "Missing Period",
+			limit: "10",
+		},
+		{
+			name: "Unknown Period",
+			limit: "10/d",
+		},
+		{
+			name: "Negative",
+			limit: "-1/m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, burst, err := ParseRate(tt.limit)
+			assert.Equal(t, err==nil, tt.valid)
+			assert.Equal(t, rate, tt.rate)
+			assert.Equal(t, burst, tt.burst)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrInvalidState = errors.New("sso: state does not match")
+	ErrInvalidNonce = errors.New("sso: nonce does not match")
+	ErrMissingIDToken = errors.New("sso: no id_token in token response")
+)
+
+// Config describes an OpenID Connect identity provider
+type Config struct {
+	// shown on the login button
+	Name string
+	Issuer string
+	ClientID string
+	ClientSecret string
+	// where the provider sends users back to, must be registered with the provider
+	RedirectURL string
+	// used for discovery, token and key requests, defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+}
+
+// Provider logs users in with an OpenID Connect identity provider
+// using the authorization code flow with PKCE
+// discovery happens on first use so the app can start while the provider is unreachable
+// signing keys are fetched from the provider's JWKS endpoint and cached until a token uses an unknown key
+type Provider struct {
+	Config Config
+
+	mu sync.Mutex
+	oauth2 *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Identity is what the provider tells us about a user
+type Identity struct {
+	Issuer string
+	Subject string
+	Name string
+	PreferredUsername string
+	Email string
+	EmailVerified bool
+}
+
+// Login holds the values which have to be kept, e.g. in the session, between
+// redirecting to the provider and handling the callback
+type Login struct {
+	State string
+	Nonce string
+	Verifier string
+}
+
+func New(config Config) *Provider {
+	if config.HTTPClient==nil {
+		config.HTTPClient = &http.Client{Timeout: 10*time.Second}
+	}
+	return &Provider{Config: config}
+}
+
+// fetch the provider's metadata once
+func (p *Provider) init() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth2!=nil {
+		return p.oauth2, p.verifier, nil
+	}
+	// the context is kept by the provider for fetching keys later, so it must not be a request context
+	ctx := oidc.ClientContext(context.Background(), p.Config.HTTPClient)
+	provider, err := oidc.NewProvider(ctx, p.Config.Issuer)
+	if err!=nil {
+		return nil, nil, fmt.Errorf("sso: discovery: %w", err)
+	}
+	p.oauth2 = &oauth2.Config{
+		ClientID: p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		RedirectURL: p.Config.RedirectURL,
+		Endpoint: provider.Endpoint(),
+		Scopes: []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.Config.ClientID})
+	return p.oauth2, p.verifier, nil
+}
+
+// start a login and return the URL of the provider to send the user to
+func (p *Provider) Start() (string, *Login, error) {
+	config, _, err := p.init()
+	if err!=nil {
+		return "", nil, err
+	}
+	state, err := randomString()
+	if err!=nil {
+		return "", nil, err
+	}
+	nonce, err := randomString()
+	if err!=nil {
+		return "", nil, err
+	}
+	login := &Login{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+	url := config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(login.Verifier))
+	return url, login, nil
+}
+
+// finish a login started with Start
+// exchanges the code for tokens and validates the ID token's signature, issuer, audience, expiry and nonce
+func (p *Provider) Finish(ctx context.Context, login *Login, state, code string) (*Identity, error) {
+	if login==nil || subtle.ConstantTimeCompare([]byte(state), []byte(login.State))!=1 {
+		return nil, ErrInvalidState
+	}
+	config, verifier, err := p.init()
+	if err!=nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, p.Config.HTTPClient)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err!=nil {
+		return nil, fmt.Errorf("sso: exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrMissingIDToken
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err!=nil {
+		return nil, fmt.Errorf("sso: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.Nonce))!=1 {
+		return nil, ErrInvalidNonce
+	}
+	var claims struct {
+		Name string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Email string `json:"email"`
+		EmailVerified bool `json:"email_verified"`
+	}
+	err = idToken.Claims(&claims)
+	if err!=nil {
+		return nil, fmt.Errorf("sso: %w", err)
+	}
+	return &Identity{
+		Issuer: idToken.Issuer,
+		Subject: idToken.Subject,
+		Name: claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Email: claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err!=nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,221 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/oauth2"
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+// a stand-in identity provider which hands out a code for every authorization request
+type testIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// code challenge and nonce for each code
+	challenges map[string]string
+	nonces map[string]string
+	// changes the claims of the next ID token
+	modify func(claims map[string]any)
+	keyRequests int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, challenges: map[string]string{}, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer": idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint": idp.URL + "/token",
+			"jwks_uri": idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.keyRequests++
+		idp.mu.Unlock()
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+		idp.mu.Lock()
+		challenge, ok := idp.challenges[code]
+		nonce := idp.nonces[code]
+		delete(idp.challenges, code)
+		modify := idp.modify
+		idp.mu.Unlock()
+		// check PKCE
+		if !ok || oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier"))!=challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{
+			"iss": idp.URL,
+			"sub": "user-1",
+			"aud": "snippetbox",
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(),
+			"nonce": nonce,
+			"name": "Alice Cooper",
+			"preferred_username": "alice",
+			"email": "alice@example.com",
+			"email_verified": true,
+		}
+		if modify!=nil {
+			modify(claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type": "Bearer",
+			"id_token": idp.sign(t, claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, claims map[string]any) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: idp.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"),
+	)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err!=nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// act like the user's browser at the authorization endpoint and return the code
+func (idp *testIdP) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	assert.Equal(t, q.Get("code_challenge_method"), "S256")
+	assert.Equal(t, strings.Contains(q.Get("scope"), "openid"), true)
+	code := "code-" + q.Get("state")
+	idp.mu.Lock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.nonces[code] = q.Get("nonce")
+	idp.mu.Unlock()
+	return code
+}
+
+func TestProvider(t *testing.T) {
+	idp := newTestIdP(t)
+	p := New(Config{Issuer: idp.URL, ClientID: "snippetbox", ClientSecret: "secret", RedirectURL: "https://localhost/callback"})
+
+	login := func(t *testing.T, modify func(map[string]any)) (*Identity, error) {
+		idp.mu.Lock()
+		idp.modify = modify
+		idp.mu.Unlock()
+		authURL, l, err := p.Start()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		code := idp.authorize(t, authURL)
+		return p.Finish(context.Background(), l, l.State, code)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		identity, err := login(t, nil)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, identity.Subject, "user-1")
+		assert.Equal(t, identity.Issuer, idp.URL)
+		assert.Equal(t, identity.Email, "alice@example.com")
+		assert.Equal(t, identity.EmailVerified, true)
+		assert.Equal(t, identity.PreferredUsername, "alice")
+	})
+
+	t.Run("Keys Are Cached", func(t *testing.T) {
+		_, err := login(t, nil)
+		assert.Equal(t, err, nil)
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		assert.Equal(t, idp.keyRequests, 1)
+	})
+
+	t.Run("Wrong Nonce", func(t *testing.T) {
+		_, err := login(t, func(c map[string]any) { c["nonce"] = "other" })
+		assert.Equal(t, errors.Is(err, ErrInvalidNonce), true)
+	})
+
+	t.Run("Wrong Audience", func(t *testing.T) {
+		_, err := login(t, func(c map[string]any) { c["aud"] = "other-app" })
+		assert.Equal(t, err!=nil, true)
+	})
+
+	t.Run("Wrong Issuer", func(t *testing.T) {
+		_, err := login(t, func(c map[string]any) { c["iss"] = "https://evil.example.com" })
+		assert.Equal(t, err!=nil, true)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, err := login(t, func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })
+		assert.Equal(t, err!=nil, true)
+	})
+
+	t.Run("Wrong State", func(t *testing.T) {
+		authURL, l, err := p.Start()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		code := idp.authorize(t, authURL)
+		_, err = p.Finish(context.Background(), l, "forged", code)
+		assert.Equal(t, errors.Is(err, ErrInvalidState), true)
+	})
+
+	t.Run("Wrong Verifier", func(t *testing.T) {
+		authURL, l, err := p.Start()
+		if err!=nil {
+			t.Fatal(err)
+		}
+		code := idp.authorize(t, authURL)
+		l.Verifier = oauth2.GenerateVerifier()
+		_, err = p.Finish(context.Background(), l, l.State, code)
+		assert.Equal(t, err!=nil, true)
+	})
+
+	t.Run("Signed By Another Key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err!=nil {
+			t.Fatal(err)
+		}
+		key := idp.key
+		idp.key = other
+		defer func() { idp.key = key }()
+		_, err = login(t, nil)
+		assert.Equal(t, err!=nil, true)
+	})
+}
@@ -0,0 +1,77 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("tokens: invalid token")
+	ErrExpiredToken = errors.New("tokens: expired token")
+)
+
+// Signer creates and verifies signed tokens which carry a small amount of data
+// tokens are signed with HMAC-SHA256 and are only valid for the purpose they were created for
+type Signer struct {
+	key []byte
+}
+
+type payload struct {
+	Data map[string]string `json:"d"`
+	Expires int64 `json:"e"`
+}
+
+// create a new Signer using key as the secret
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// create a token for purpose which carries data and is valid for ttl
+func (s *Signer) Sign(purpose string, data map[string]string, ttl time.Duration) (string, error) {
+	js, err := json.Marshal(payload{
+		Data: data,
+		Expires: time.Now().Add(ttl).Unix(),
+	})
+	if err!=nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(js)
+	return encoded + "." + s.signature(purpose, encoded), nil
+}
+
+// check the signature and expiry of a token created for purpose and return its data
+func (s *Signer) Verify(purpose, token string) (map[string]string, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(purpose, encoded))) {
+		return nil, ErrInvalidToken
+	}
+	js, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err!=nil {
+		return nil, ErrInvalidToken
+	}
+	var p payload
+	err = json.Unmarshal(js, &p)
+	if err!=nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > p.Expires {
+		return nil, ErrExpiredToken
+	}
+	return p.Data, nil
+}
+
+// the purpose is part of the signed message so that a token
+// created for one purpose can not be used for another
+func (s *Signer) signature(purpose, encoded string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(purpose + "." + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token, err := signer.Sign("verify-email", map[string]string{"user": "1"}, time.Hour)
+	if err!=nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		data, err := signer.Verify("verify-email", token)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, data["user"], "1")
+	})
+
+	t.Run("Wrong Purpose", func(t *testing.T) {
+		_, err := signer.Verify("reset-password", token)
+		assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+	})
+
+	t.Run("Wrong Key", func(t *testing.T) {
+		_, err := NewSigner([]byte("other")).Verify("verify-email", token)
+		assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		_, err := signer.Verify("verify-email", "x"+token)
+		assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired, err := signer.Sign("verify-email", nil, -time.Minute)
+		if err!=nil {
+			t.Fatal(err)
+		}
+		_, err = signer.Verify("verify-email", expired)
+		assert.Equal(t, errors.Is(err, ErrExpiredToken), true)
+	})
+}
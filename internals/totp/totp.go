@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// settings used by standard authenticator apps
+const (
+	Digits = 6
+	Period = 30
+	// number of periods before and after the current one in which a code is still accepted
+	// this allows for clock drift between the server and the user's device
+	Skew = 1
+)
+
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// create a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err!=nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// return the time step t falls in
+func Step(t time.Time) uint64 {
+	return uint64(t.Unix() / Period)
+}
+
+// return the code for secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err!=nil {
+		return "", err
+	}
+	return hotp(key, Step(t), Digits), nil
+}
+
+// check code against secret at time t
+// returns the time step the code belongs to so callers can reject codes which were already used
+func Validate(secret, code string, t time.Time) (uint64, bool) {
+	key, err := decodeSecret(secret)
+	if err!=nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code)!=Digits {
+		return 0, false
+	}
+	step := Step(t)
+	for i := -Skew; i <= Skew; i++ {
+		s := step + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, s, Digits)), []byte(code))==1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// return the otpauth:// URL which authenticator apps read from a QR code
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err!=nil || len(key)==0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// HOTP as defined in RFC 4226 using HMAC-SHA1
+func hotp(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
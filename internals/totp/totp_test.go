@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+// test vectors from RFC 6238 appendix B for SHA1
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "59", time: 59, want: "94287082"},
+		{name: "1111111109", time: 1111111109, want: "07081804"},
+		{name: "1111111111", time: 1111111111, want: "14050471"},
+		{name: "1234567890", time: 1234567890, want: "89005924"},
+		{name: "2000000000", time: 2000000000, want: "69279037"},
+		{name: "20000000000", time: 20000000000, want: "65353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hotp(key, Step(time.Unix(tt.time, 0)), 8)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := Code(secret, now)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	// last 6 digits of the RFC 6238 vector
+	assert.Equal(t, code, "050471")
+
+	tests := []struct {
+		name string
+		time time.Time
+		code string
+		want bool
+	}{
+		{name: "Current", time: now, code: code, want: true},
+		{name: "Previous Step", time: now.Add(Period*time.Second), code: code, want: true},
+		{name: "Next Step", time: now.Add(-Period*time.Second), code: code, want: true},
+		{name: "Too Late", time: now.Add(2*Period*time.Second), code: code, want: false},
+		{name: "Spaces", time: now, code: "050 471", want: true},
+		{name: "Wrong", time: now, code: "123456", want: false},
+		{name: "Short", time: now, code: "05047", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := Validate(secret, tt.code, tt.time)
+			assert.Equal(t, ok, tt.want)
+		})
+	}
+
+	step, _ := Validate(secret, code, now.Add(Period*time.Second))
+	assert.Equal(t, step, Step(now))
+}
+
+func TestURL(t *testing.T) {
+	u := URL("SnippetBox", "bob@example.com", "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, strings.HasPrefix(u, "otpauth://totp/SnippetBox:bob@example.com?"), true)
+	assert.Equal(t, strings.Contains(u, "secret=JBSWY3DPEHPK3PXP"), true)
+}
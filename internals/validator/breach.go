@@ -0,0 +1,153 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// size of a record in a breached password file
+const breachRecordSize = sha1.Size
+
+var (
+	ErrInvalidBreachList = errors.New("validator: invalid breached password file")
+	ErrUnsortedHashes = errors.New("validator: hashes are not in ascending order")
+)
+
+// BreachList checks passwords against a list of breached passwords
+// the list is a file of raw SHA-1 hashes sorted in ascending order, 20 bytes each
+// lookups are a binary search straight on the file so it never has to fit in memory
+type BreachList struct {
+	r io.ReaderAt
+	n int64
+	closer io.Closer
+}
+
+// open a breached password file created with WriteBreachList
+func OpenBreachList(path string) (*BreachList, error) {
+	f, err := os.Open(path)
+	if err!=nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err!=nil {
+		f.Close()
+		return nil, err
+	}
+	b, err := NewBreachList(f, info.Size())
+	if err!=nil {
+		f.Close()
+		return nil, err
+	}
+	b.closer = f
+	return b, nil
+}
+
+// use size bytes of r as a breached password list
+func NewBreachList(r io.ReaderAt, size int64) (*BreachList, error) {
+	if size%breachRecordSize!=0 {
+		return nil, ErrInvalidBreachList
+	}
+	return &BreachList{r: r, n: size/breachRecordSize}, nil
+}
+
+// number of hashes in the list
+func (b *BreachList) Len() int64 {
+	return b.n
+}
+
+// check if password is in the list
+func (b *BreachList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	return b.ContainsHash(sum)
+}
+
+// check if the SHA-1 hash of a password is in the list
+func (b *BreachList) ContainsHash(sum [sha1.Size]byte) (bool, error) {
+	record := make([]byte, breachRecordSize)
+	lo, hi := int64(0), b.n
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		_, err := b.r.ReadAt(record, mid*breachRecordSize)
+		if err!=nil {
+			return false, err
+		}
+		switch bytes.Compare(record, sum[:]) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (b *BreachList) Close() error {
+	if b.closer==nil {
+		return nil
+	}
+	return b.closer.Close()
+}
+
+// write hashes to w in the format read by BreachList
+// hashes are sorted and duplicates are dropped
+func WriteBreachList(w io.Writer, hashes [][sha1.Size]byte) error {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	bw := NewBreachListWriter(w)
+	for _, h := range hashes {
+		err := bw.Add(h)
+		if err!=nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// BreachListWriter writes hashes which are already sorted in the format read by BreachList
+// one at a time, so lists too big for memory such as the Have I Been Pwned downloads can be converted
+type BreachListWriter struct {
+	w *bufio.Writer
+	last [sha1.Size]byte
+	n int64
+}
+
+func NewBreachListWriter(w io.Writer) *BreachListWriter {
+	return &BreachListWriter{w: bufio.NewWriter(w)}
+}
+
+// write the next hash, duplicates of the previous hash are dropped
+// returns ErrUnsortedHashes if h comes before the previous hash
+func (b *BreachListWriter) Add(h [sha1.Size]byte) error {
+	if b.n > 0 {
+		switch bytes.Compare(h[:], b.last[:]) {
+		case 0:
+			return nil
+		case -1:
+			return ErrUnsortedHashes
+		}
+	}
+	_, err := b.w.Write(h[:])
+	if err!=nil {
+		return err
+	}
+	b.last = h
+	b.n++
+	return nil
+}
+
+// number of hashes written
+func (b *BreachListWriter) Len() int64 {
+	return b.n
+}
+
+func (b *BreachListWriter) Flush() error {
+	return b.w.Flush()
+}
@@ -0,0 +1,299 @@
+package validator
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// common passwords and words, most common first
+//
+//go:embed words.txt
+var wordList string
+
+var words = strings.Fields(wordList)
+
+// rows of a qwerty keyboard, used to find keyboard patterns
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// characters people commonly use in place of letters
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+}
+
+// Strength is an estimate of how hard a password is to guess
+type Strength struct {
+	// 0 (very weak) to 4 (very strong)
+	Score int
+	// estimated entropy in bits
+	Entropy float64
+	Feedback []string
+}
+
+// labels for each score
+var StrengthLabels = []string{"Very weak", "Weak", "Fair", "Strong", "Very strong"}
+
+func (s Strength) Label() string {
+	return StrengthLabels[s.Score]
+}
+
+// kinds of guessable parts found in a password
+const (
+	matchDictionary = iota
+	matchUserInput
+	matchKeyboard
+	matchSequence
+	matchRepeat
+)
+
+type match struct {
+	start, end int
+	kind int
+	entropy float64
+}
+
+// estimate the strength of a password
+// parts of the password which are dictionary words, keyboard patterns, sequences, repeated characters
+// or taken from userInputs such as the user's name and email count for much less than random characters
+func PasswordStrength(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes)==0 {
+		return Strength{Feedback: []string{"Enter a password"}}
+	}
+	lower := []rune(strings.ToLower(password))
+	// compare words against the password with common substitutions undone
+	normalized := make([]rune, len(lower))
+	for i, r := range lower {
+		if l, ok := leet[r]; ok {
+			normalized[i] = l
+		} else {
+			normalized[i] = r
+		}
+	}
+
+	var matches []match
+	for rank, word := range words {
+		matches = append(matches, findWord(runes, lower, normalized, word, matchDictionary, math.Log2(float64(rank+2)))...)
+	}
+	for _, token := range inputTokens(userInputs) {
+		matches = append(matches, findWord(runes, lower, normalized, token, matchUserInput, 1)...)
+	}
+	matches = append(matches, findSequences(lower)...)
+	matches = append(matches, findRepeats(lower)...)
+
+	// find the cheapest way to build the password out of matches and random characters
+	perChar := math.Log2(float64(charsetSize(runes)))
+	best := make([]float64, len(runes)+1)
+	used := make([]*match, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] + perChar
+		used[i] = nil
+		for j := range matches {
+			m := &matches[j]
+			if m.end==i && best[m.start]+m.entropy < best[i] {
+				best[i] = best[m.start] + m.entropy
+				used[i] = m
+			}
+		}
+	}
+	kinds := map[int]bool{}
+	for i := len(runes); i > 0; {
+		if used[i]==nil {
+			i--
+			continue
+		}
+		kinds[used[i].kind] = true
+		i = used[i].start
+	}
+
+	entropy := best[len(runes)]
+	s := Strength{Entropy: entropy, Score: score(entropy)}
+	if s.Score >= 3 {
+		return s
+	}
+	if kinds[matchUserInput] {
+		s.Feedback = append(s.Feedback, "Don't use your name, username or email address")
+	}
+	if kinds[matchDictionary] {
+		s.Feedback = append(s.Feedback, "Avoid common words and passwords")
+	}
+	if kinds[matchKeyboard] || kinds[matchSequence] {
+		s.Feedback = append(s.Feedback, "Avoid keyboard patterns and sequences like qwerty or 1234")
+	}
+	if kinds[matchRepeat] {
+		s.Feedback = append(s.Feedback, "Avoid repeated characters")
+	}
+	if len(runes) < 12 {
+		s.Feedback = append(s.Feedback, "Use a longer password, a few unrelated words work well")
+	}
+	return s
+}
+
+// check if a password has at least minScore
+func StrongPassword(password string, minScore int, userInputs ...string) bool {
+	return PasswordStrength(password, userInputs...).Score >= minScore
+}
+
+func score(entropy float64) int {
+	switch {
+	case entropy < 25:
+		return 0
+	case entropy < 35:
+		return 1
+	case entropy < 50:
+		return 2
+	case entropy < 65:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// number of possible characters based on the kinds of characters in the password
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return size
+}
+
+// find all occurrences of word in the normalized password
+// capital letters and substitutions add a little entropy each
+func findWord(runes, lower, normalized []rune, word string, kind int, entropy float64) []match {
+	w := []rune(word)
+	var matches []match
+	for i := 0; i+len(w) <= len(normalized); i++ {
+		if string(normalized[i:i+len(w)])!=word {
+			continue
+		}
+		e := entropy
+		if string(runes[i:i+len(w)])!=string(lower[i:i+len(w)]) {
+			e++
+		}
+		if string(lower[i:i+len(w)])!=word {
+			e++
+		}
+		matches = append(matches, match{start: i, end: i+len(w), kind: kind, entropy: e})
+	}
+	return matches
+}
+
+// split user inputs into words which might be used in a password
+func inputTokens(userInputs []string) []string {
+	var tokens []string
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		fields := strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		// the whole local part of an email address as well as its parts
+		if local, _, found := strings.Cut(input, "@"); found {
+			fields = append(fields, local)
+		}
+		for _, f := range fields {
+			if len([]rune(f)) >= 3 {
+				tokens = append(tokens, f)
+			}
+		}
+	}
+	return tokens
+}
+
+// find runs of at least 3 characters which are next to each other on the keyboard or in the alphabet
+func findSequences(lower []rune) []match {
+	var matches []match
+	for _, adjacent := range []struct {
+		kind int
+		next func(a, b rune) bool
+	}{
+		{kind: matchKeyboard, next: keyboardAdjacent},
+		{kind: matchSequence, next: func(a, b rune) bool {
+			return (b==a+1 || b==a-1) && (unicode.IsLetter(a) || unicode.IsDigit(a))
+		}},
+	} {
+		start := 0
+		for i := 1; i <= len(lower); i++ {
+			if i < len(lower) && adjacent.next(lower[i-1], lower[i]) {
+				continue
+			}
+			if i-start >= 3 {
+				// choice of starting point and direction, plus a little for the length
+				e := math.Log2(40) + math.Log2(float64(i-start))
+				matches = append(matches, match{start: start, end: i, kind: adjacent.kind, entropy: e})
+			}
+			start = i
+		}
+	}
+	return matches
+}
+
+// check if b comes right before or after a on the same keyboard row
+func keyboardAdjacent(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		j := strings.IndexRune(row, b)
+		if i >= 0 && j >= 0 && (j==i+1 || j==i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// find runs of at least 3 repeated characters
+func findRepeats(lower []rune) []match {
+	var matches []match
+	start := 0
+	for i := 1; i <= len(lower); i++ {
+		if i < len(lower) && lower[i]==lower[start] {
+			continue
+		}
+		if i-start >= 3 {
+			e := math.Log2(95) + math.Log2(float64(i-start))
+			matches = append(matches, match{start: start, end: i, kind: matchRepeat, entropy: e})
+		}
+		start = i
+	}
+	return matches
+}
@@ -61,7 +61,7 @@ func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 
 // check if string is at least limit chars long
 func MinLen(value string, limit int) bool {
-	return utf8.RuneCountInString(value)>=8	
+	return utf8.RuneCountInString(value)>=limit
 }
 
 // regex for checking email
@@ -71,3 +71,7 @@ var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// regex for checking usernames
+// lowercase letters, digits, hyphens and underscores, starting and ending with a letter or digit
+var UsernameRX = regexp.MustCompile("^[a-z0-9](?:[a-z0-9_-]*[a-z0-9])?$")
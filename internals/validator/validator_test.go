@@ -0,0 +1,80 @@
+package validator
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"snippetbox.anukuljoshi/internals/assert"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name string
+		password string
+		maxScore int
+		minScore int
+	}{
+		{name: "Empty", password: "", minScore: 0, maxScore: 0},
+		{name: "Common Password", password: "password1", minScore: 0, maxScore: 0},
+		{name: "Substitutions", password: "P@ssw0rd!", minScore: 0, maxScore: 0},
+		{name: "Keyboard Pattern", password: "qwerty123", minScore: 0, maxScore: 0},
+		{name: "Repeated", password: "aaaaaaaaaa", minScore: 0, maxScore: 0},
+		{name: "Own Name", password: "alicecooper", minScore: 0, maxScore: 1},
+		{name: "Own Email", password: "alice.cooper1", minScore: 0, maxScore: 1},
+		{name: "Random", password: "xK9#mQ2$vL7!", minScore: 4, maxScore: 4},
+		{name: "Long Passphrase", password: "violin tulip gravel orbit", minScore: 3, maxScore: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := PasswordStrength(tt.password, "Alice Cooper", "alice.cooper@example.com")
+			assert.Equal(t, s.Score >= tt.minScore && s.Score <= tt.maxScore, true)
+			assert.Equal(t, len(s.Feedback) > 0, s.Score < 3)
+		})
+	}
+}
+
+func TestBreachList(t *testing.T) {
+	var hashes [][sha1.Size]byte
+	for _, p := range []string{"hunter2", "123456", "letmein", "hunter2"} {
+		hashes = append(hashes, sha1.Sum([]byte(p)))
+	}
+	var buf bytes.Buffer
+	err := WriteBreachList(&buf, hashes)
+	if err!=nil {
+		t.Fatal(err)
+	}
+	list, err := NewBreachList(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err!=nil {
+		t.Fatal(err)
+	}
+	// duplicates are dropped
+	assert.Equal(t, list.Len(), int64(3))
+
+	for _, p := range []string{"hunter2", "123456", "letmein"} {
+		found, err := list.Contains(p)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, found, true)
+	}
+	found, err := list.Contains("violin tulip gravel orbit")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found, false)
+
+	_, err = NewBreachList(bytes.NewReader([]byte("short")), 5)
+	assert.Equal(t, err, ErrInvalidBreachList)
+}
+
+func TestBreachListWriter(t *testing.T) {
+	a := [sha1.Size]byte{1}
+	b := [sha1.Size]byte{2}
+	var buf bytes.Buffer
+	w := NewBreachListWriter(&buf)
+	assert.Equal(t, w.Add(a), nil)
+	assert.Equal(t, w.Add(a), nil)
+	assert.Equal(t, w.Add(b), nil)
+	// hashes have to be added in ascending order
+	assert.Equal(t, w.Add(a), ErrUnsortedHashes)
+	assert.Equal(t, w.Flush(), nil)
+	assert.Equal(t, w.Len(), int64(2))
+	assert.Equal(t, buf.Len(), 2*sha1.Size)
+}
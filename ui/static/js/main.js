@@ -6,3 +6,249 @@ for (var i = 0; i < navLinks.length; i++) {
 		break;
 	}
 }
+
+// live preview for the create snippet form
+// sends the draft content to the server once the user stops typing
+var content = document.getElementById("content");
+var preview = document.getElementById("preview");
+var previewFormat = document.getElementById("format");
+var previewLanguage = document.getElementById("language");
+if (content && preview && previewFormat && previewLanguage) {
+	var previewTimer;
+	var updatePreview = function () {
+		var body = new URLSearchParams();
+		body.append("csrf_token", content.form.elements["csrf_token"].value);
+		body.append("content", content.value);
+		body.append("format", previewFormat.value);
+		body.append("language", previewLanguage.value);
+		fetch(preview.dataset.url, {method: "POST", body: body, credentials: "same-origin"})
+			.then(function (response) {
+				if (!response.ok) {
+					throw new Error(response.statusText);
+				}
+				return response.text();
+			})
+			.then(function (html) {
+				preview.innerHTML = html;
+			})
+			.catch(function () {
+				// keep showing the last preview if the request failed or was rate limited
+			});
+	};
+	content.addEventListener("input", function () {
+		clearTimeout(previewTimer);
+		previewTimer = setTimeout(updatePreview, 500);
+	});
+	// the language only matters for code
+	var updateOptions = function () {
+		previewLanguage.disabled = previewFormat.value == "markdown";
+		updatePreview();
+	};
+	previewFormat.addEventListener("change", updateOptions);
+	previewLanguage.addEventListener("change", updatePreview);
+	updateOptions();
+}
+
+// autosave for forms marked with data-autosave
+// posts the form state every few seconds when it has changed since the last save
+var autosaveForm = document.querySelector("form[data-autosave]");
+if (autosaveForm) {
+	var autosaveStatus = autosaveForm.querySelector(".autosave-status");
+	var autosaveState = function () {
+		var body = new URLSearchParams(new FormData(autosaveForm));
+		body.delete("draft_id");
+		body.delete("csrf_token");
+		return body.toString();
+	};
+	var lastSaved = autosaveState();
+	setInterval(function () {
+		var state = autosaveState();
+		if (state == lastSaved) {
+			return;
+		}
+		fetch(autosaveForm.dataset.autosave, {
+			method: "POST",
+			body: new URLSearchParams(new FormData(autosaveForm)),
+			credentials: "same-origin",
+		})
+			.then(function (response) {
+				if (!response.ok || response.redirected) {
+					throw new Error(response.statusText);
+				}
+				return response.json();
+			})
+			.then(function (draft) {
+				lastSaved = state;
+				autosaveForm.elements["draft_id"].value = draft.id;
+				if (autosaveStatus) {
+					autosaveStatus.textContent = "Draft saved at " + new Date().toLocaleTimeString();
+				}
+			})
+			.catch(function () {
+				if (autosaveStatus) {
+					autosaveStatus.textContent = "Draft could not be saved";
+				}
+			});
+	}, 5000);
+}
+
+// line permalinks for snippet content
+// clicking a line number selects the line, shift clicking extends the selection
+// the selection is kept in the url as ?lines=12-20#L12-L20 so the server can highlight it
+var lineNumbers = document.querySelectorAll(".lines .line-number");
+if (lineNumbers.length > 0) {
+	var selectedStart = 0;
+	var highlightLines = function (start, end) {
+		var lines = document.querySelectorAll(".lines .line");
+		for (var i = 0; i < lines.length; i++) {
+			var number = i + 1;
+			lines[i].classList.toggle("highlighted", number >= start && number <= end);
+		}
+	};
+	var parseLines = function (value) {
+		var match = /^L?(\d+)(?:-L?(\d+))?$/.exec(value);
+		if (!match) {
+			return null;
+		}
+		var start = parseInt(match[1], 10);
+		var end = match[2] ? parseInt(match[2], 10) : start;
+		return start <= end ? [start, end] : [end, start];
+	};
+	for (var i = 0; i < lineNumbers.length; i++) {
+		lineNumbers[i].addEventListener("click", function (event) {
+			event.preventDefault();
+			var number = parseInt(this.dataset.line, 10);
+			var start = number;
+			var end = number;
+			if (event.shiftKey && selectedStart) {
+				start = Math.min(selectedStart, number);
+				end = Math.max(selectedStart, number);
+			} else {
+				selectedStart = number;
+			}
+			var range = start == end ? String(start) : start + "-" + end;
+			var hash = start == end ? "#L" + start : "#L" + start + "-L" + end;
+			var url = new URL(window.location.href);
+			url.searchParams.set("lines", range);
+			url.hash = hash;
+			history.replaceState(null, "", url.toString());
+			highlightLines(start, end);
+			// a single selected line is used as the line for a new comment
+			var commentLine = document.getElementById("comment-line");
+			if (commentLine && start == end) {
+				commentLine.value = start;
+			}
+		});
+	}
+	// highlight the range in the fragment when the server did not highlight anything
+	var fragment = parseLines(window.location.hash.slice(1));
+	if (fragment && !document.querySelector(".lines .line.highlighted")) {
+		highlightLines(fragment[0], fragment[1]);
+		selectedStart = fragment[0];
+		var first = document.getElementById("L" + fragment[0]);
+		if (first) {
+			first.scrollIntoView();
+		}
+	}
+}
+
+// strength meter for password inputs marked with data-strength
+// the server estimates the strength so the same rules apply as when the form is submitted
+var strengthInputs = document.querySelectorAll("input[data-strength]");
+for (var i = 0; i < strengthInputs.length; i++) {
+	(function (input) {
+		var meter = input.parentElement.querySelector(".password-strength");
+		var timer;
+		var check = function () {
+			if (input.value == "") {
+				meter.hidden = true;
+				return;
+			}
+			var elements = input.form.elements;
+			var body = new URLSearchParams();
+			body.append("csrf_token", elements["csrf_token"].value);
+			body.append("password", input.value);
+			["name", "username", "email"].forEach(function (name) {
+				if (elements[name]) {
+					body.append(name, elements[name].value);
+				}
+			});
+			fetch(input.dataset.strength, {method: "POST", body: body, credentials: "same-origin"})
+				.then(function (response) {
+					if (!response.ok) {
+						throw new Error(response.statusText);
+					}
+					return response.json();
+				})
+				.then(function (result) {
+					meter.hidden = false;
+					meter.querySelector("meter").value = result.score;
+					meter.querySelector(".label").textContent = result.label;
+					meter.querySelector(".feedback").textContent = (result.feedback || []).join(". ");
+				})
+				.catch(function () {
+					// the meter is only a hint, the form is checked again when it is submitted
+				});
+		};
+		input.addEventListener("input", function () {
+			clearTimeout(timer);
+			timer = setTimeout(check, 300);
+		});
+	})(strengthInputs[i]);
+}
+
+// proof of work for forms with a bot check challenge
+// finds a number whose SHA-256 hash together with the challenge starts with enough zero bits
+// the search starts when the page loads, submitting waits for it to finish
+var challengeInput = document.querySelector("input[name=challenge]");
+if (challengeInput && parseInt(challengeInput.dataset.difficulty, 10) > 0 && window.crypto && crypto.subtle) {
+	var challengeForm = challengeInput.form;
+	var difficulty = parseInt(challengeInput.dataset.difficulty, 10);
+	var encoder = new TextEncoder();
+	var solved = false;
+	var submitWhenSolved = false;
+	var leadingZeros = function (hash) {
+		var bytes = new Uint8Array(hash);
+		var n = 0;
+		for (var i = 0; i < bytes.length; i++) {
+			if (bytes[i] != 0) {
+				return n + Math.clz32(bytes[i]) - 24;
+			}
+			n += 8;
+		}
+		return n;
+	};
+	var search = function (start) {
+		var hashes = [];
+		for (var i = start; i < start + 1000; i++) {
+			hashes.push(crypto.subtle.digest("SHA-256", encoder.encode(challengeInput.value + ":" + i)));
+		}
+		return Promise.all(hashes).then(function (hashes) {
+			for (var i = 0; i < hashes.length; i++) {
+				if (leadingZeros(hashes[i]) >= difficulty) {
+					return start + i;
+				}
+			}
+			return search(start + 1000);
+		});
+	};
+	search(0).then(function (solution) {
+		challengeForm.elements["solution"].value = solution;
+		solved = true;
+		if (submitWhenSolved) {
+			challengeForm.submit();
+		}
+	});
+	challengeForm.addEventListener("submit", function (event) {
+		if (solved) {
+			return;
+		}
+		event.preventDefault();
+		submitWhenSolved = true;
+		var button = challengeForm.querySelector("input[type=submit]");
+		if (button) {
+			button.disabled = true;
+			button.value = "Please wait...";
+		}
+	});
+}